@@ -6,11 +6,11 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/unixpickle/essentials v1.3.0
 	github.com/unixpickle/lutronbroker v0.1.4
+	golang.org/x/sync v0.7.0
 )
 
 require (
 	github.com/eclipse/paho.mqtt.golang v1.5.0 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	golang.org/x/net v0.27.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
 )
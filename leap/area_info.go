@@ -0,0 +1,162 @@
+package leap
+
+import (
+	"context"
+	"log"
+
+	"github.com/unixpickle/essentials"
+)
+
+// AreaInfo is an area with the devices and zones assigned to it, and its
+// sub-areas.
+type AreaInfo struct {
+	Href    string
+	Name    string
+	Devices []*DeviceInfo `json:",omitempty"`
+	Zones   []*ZoneInfo   `json:",omitempty"`
+	Areas   []*AreaInfo   `json:",omitempty"`
+
+	// Occupancy is "Occupied", "Unoccupied" or "Unknown" if the area has
+	// occupancy sensors.
+	Occupancy string `json:",omitempty"`
+
+	// OnCount is the number of lights which are on in this area and its
+	// sub-areas, and AverageLevel is the average level of those lights, or
+	// nil if there are none.
+	OnCount      int
+	AverageLevel *float64 `json:",omitempty"`
+}
+
+// GetAreas reads the area tree, with the devices and zones in each area.
+func GetAreas(ctx context.Context, conn BrokerConn, cache Cache) ([]*AreaInfo, error) {
+	return getAreas(ctx, conn, cache, nil)
+}
+
+// getAreas is like GetAreas, but reports non-fatal errors to logger.
+func getAreas(
+	ctx context.Context,
+	conn BrokerConn,
+	cache Cache,
+	logger *log.Logger,
+) (areas []*AreaInfo, err error) {
+	defer essentials.AddCtxTo("get areas", &err)
+
+	var zoneResponse struct {
+		ZoneStatuses []ZoneStatus
+	}
+	if err := ReadRequest(ctx, conn, "/zone/status", &zoneResponse); err != nil {
+		return nil, err
+	}
+	data, err := fetchDeviceData(ctx, conn, cache, zoneResponse.ZoneStatuses, logger)
+	if err != nil {
+		return nil, err
+	}
+	return data.AreaInfos(), nil
+}
+
+// AreaInfos builds the area tree from the raw data, returning the root
+// areas.
+//
+// Devices and zones without an area are omitted.
+func (d *deviceData) AreaInfos() []*AreaInfo {
+	areas := map[string]*AreaInfo{}
+	for _, area := range d.Areas {
+		areas[area.Href] = &AreaInfo{
+			Href:      area.Href,
+			Name:      area.Name,
+			Occupancy: d.areaOccupancy(area.Href),
+		}
+	}
+
+	deviceInfos := d.DeviceInfos()
+	for i, device := range d.Devices {
+		if device.AssociatedArea == nil {
+			continue
+		}
+		if area, ok := areas[device.AssociatedArea.Href]; ok {
+			area.Devices = append(area.Devices, deviceInfos[i])
+		}
+	}
+
+	// Zones are in the area of their device, unless they specify their own.
+	zoneAreas := map[string]string{}
+	for _, device := range d.Devices {
+		if device.AssociatedArea != nil {
+			for _, zone := range device.LocalZones {
+				zoneAreas[zone.Href] = device.AssociatedArea.Href
+			}
+		}
+	}
+	for _, zone := range d.Zones {
+		if zone.AssociatedArea != nil {
+			zoneAreas[zone.Href] = zone.AssociatedArea.Href
+		}
+	}
+	for _, zone := range d.zoneInfos() {
+		if area, ok := areas[zoneAreas[zone.Href]]; ok {
+			area.Zones = append(area.Zones, zone)
+		}
+	}
+
+	var roots []*AreaInfo
+	for _, area := range d.Areas {
+		info := areas[area.Href]
+		if area.Parent == nil || areas[area.Parent.Href] == nil {
+			roots = append(roots, info)
+		} else {
+			parent := areas[area.Parent.Href]
+			parent.Areas = append(parent.Areas, info)
+		}
+	}
+	for _, root := range roots {
+		root.summarize()
+	}
+	return roots
+}
+
+// zoneInfos lists the known zones, in the order the bridge listed them.
+func (d *deviceData) zoneInfos() []*ZoneInfo {
+	var res []*ZoneInfo
+	seen := map[string]bool{}
+	add := func(zone Zone) {
+		if !seen[zone.Href] {
+			seen[zone.Href] = true
+			res = append(res, d.zoneInfo(zone))
+		}
+	}
+	for _, zone := range d.Zones {
+		add(zone)
+	}
+	for _, device := range d.Devices {
+		for _, link := range device.LocalZones {
+			add(Zone{Href: link.Href})
+		}
+	}
+	return res
+}
+
+// summarize computes OnCount and AverageLevel for a subtree, returning the
+// number of lights with a known level and the sum of their levels.
+func (a *AreaInfo) summarize() (count, total int) {
+	for _, zone := range a.Zones {
+		if zone.Level == nil || !IsLight(zone.ControlType) {
+			continue
+		}
+		count++
+		total += *zone.Level
+		if *zone.Level > 0 {
+			a.OnCount++
+		}
+	}
+	for _, child := range a.Areas {
+		childCount, childTotal := child.summarize()
+		count += childCount
+		total += childTotal
+		a.OnCount += child.OnCount
+	}
+	if count > 0 {
+		average := float64(total) / float64(count)
+		a.AverageLevel = &average
+	}
+	return count, total
+}
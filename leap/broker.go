@@ -0,0 +1,517 @@
+// Package leap talks to Lutron bridges using the LEAP protocol, either
+// through the Lutron cloud broker or any other BrokerConn.
+package leap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/unixpickle/essentials"
+)
+
+type Header struct {
+	ClientTag       string
+	Url             string
+	StatusCode      string `json:",omitempty"`
+	MessageBodyType string `json:",omitempty"`
+}
+
+type Message struct {
+	CommuniqueType string
+	Header         Header
+	Body           json.RawMessage `json:",omitempty"`
+}
+
+func (m Message) String() string {
+	return fmt.Sprintf(
+		"Message(CommuniqueType=%s, Header=%s, Body=%s)",
+		m.CommuniqueType,
+		m.Header,
+		string(m.Body),
+	)
+}
+
+type BrokerConn interface {
+	Send(_ Message) error
+	Subscribe(_ context.Context, _ chan<- Message, _ ...func() error) error
+	Call(_ context.Context, _ Message, _ func(Message) (bool, error)) (Message, error)
+	Close() error
+	Error() error
+}
+
+// ReadRequest sends a ReadRequest to the given URL and parses the result into
+// a specified JSON object `result`.
+//
+// Transient failures are retried according to DefaultRetryPolicy.
+// If the bridge responds with an error status, a *LEAPError is returned.
+func ReadRequest(ctx context.Context, conn BrokerConn, url string, result any) error {
+	return ReadRequestWithRetry(ctx, conn, url, result, DefaultRetryPolicy)
+}
+
+// ReadRequestWithRetry is like ReadRequest() with a custom retry policy.
+//
+// If the policy has an AttemptTimeout, concurrent reads of the same URL on the
+// same connection with the same policy are coalesced into a single request.
+func ReadRequestWithRetry(
+	ctx context.Context,
+	conn BrokerConn,
+	url string,
+	result any,
+	policy RetryPolicy,
+) (err error) {
+	defer essentials.AddCtxTo("request "+url, &err)
+
+	read := func(ctx context.Context) (json.RawMessage, error) {
+		var response Message
+		err := policy.Do(ctx, func(ctx context.Context) error {
+			msg, err := newRequest("ReadRequest", url, nil)
+			if err != nil {
+				return err
+			}
+			response, err = callRequest(ctx, conn, msg)
+			return err
+		})
+		return response.Body, err
+	}
+
+	var body json.RawMessage
+	if policy.AttemptTimeout == 0 {
+		// Without a timeout, a coalesced read might never finish.
+		body, err = read(ctx)
+	} else {
+		// Callers with different policies must not wait on (or share the
+		// failures of) each other's retry loops.
+		key := ConnKey(conn, fmt.Sprintf("%s %+v", url, policy))
+		body, err = readCoalescer.Do(ctx, key, read)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(body), result)
+}
+
+// BatchOptions controls how ReadRequests() issues batch reads.
+type BatchOptions struct {
+	// MaxParallel limits the number of requests in flight at once.
+	// If it is zero or negative, there is no limit.
+	MaxParallel int
+
+	// CancelOnError causes the batch to stop after the first failed request,
+	// cancelling requests in flight and returning only the first error.
+	//
+	// Otherwise, every request is attempted, and failures are returned as a
+	// *BatchError alongside the successful results.
+	CancelOnError bool
+}
+
+// DefaultBatchOptions is used by ReadRequests() and ReadRequestsAsMap().
+var DefaultBatchOptions = BatchOptions{
+	MaxParallel:   16,
+	CancelOnError: true,
+}
+
+// A BatchError reports the requests that failed in a batch read.
+type BatchError struct {
+	Total    int
+	Failures map[string]error
+}
+
+func (b *BatchError) Error() string {
+	if len(b.Failures) == 0 {
+		return fmt.Sprintf("0 of %d requests failed", b.Total)
+	}
+	urls := make([]string, 0, len(b.Failures))
+	for url := range b.Failures {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return fmt.Sprintf(
+		"%d of %d requests failed (first: %s: %s)",
+		len(b.Failures),
+		b.Total,
+		urls[0],
+		b.Failures[urls[0]],
+	)
+}
+
+func (b *BatchError) Unwrap() []error {
+	var res []error
+	for _, err := range b.Failures {
+		res = append(res, err)
+	}
+	return res
+}
+
+// ReadRequests is a concurrent version of ReadRequest() to do batch reads
+// using DefaultBatchOptions.
+func ReadRequests[T any](ctx context.Context, conn BrokerConn, urls []string, results []T) error {
+	return ReadRequestsWithOptions(ctx, conn, urls, results, DefaultBatchOptions)
+}
+
+// ReadRequestsWithOptions is like ReadRequests() with custom options.
+//
+// If opts.CancelOnError is false, results are filled in for every successful
+// request even if a *BatchError is returned.
+func ReadRequestsWithOptions[T any](
+	ctx context.Context,
+	conn BrokerConn,
+	urls []string,
+	results []T,
+	opts BatchOptions,
+) (err error) {
+	if len(urls) == 0 {
+		return nil
+	}
+	defer essentials.AddCtxTo("request multiple URLs", &err)
+
+	if len(urls) != len(results) {
+		panic("number of URLs must match number of results")
+	}
+
+	maxParallel := opts.MaxParallel
+	if maxParallel <= 0 {
+		maxParallel = len(urls)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var firstErrOnce sync.Once
+	errs := make([]error, len(urls))
+	sem := make(chan struct{}, maxParallel)
+	wg := sync.WaitGroup{}
+	for i := range urls {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := ReadRequest(ctx, conn, urls[i], &results[i]); err != nil {
+				errs[i] = err
+				if opts.CancelOnError {
+					firstErrOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if opts.CancelOnError {
+		if firstErr != nil {
+			return firstErr
+		}
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	batchErr := &BatchError{Total: len(urls), Failures: map[string]error{}}
+	for i, err := range errs {
+		if err != nil {
+			batchErr.Failures[urls[i]] = err
+		}
+	}
+	if len(batchErr.Failures) > 0 {
+		return batchErr
+	}
+	return nil
+}
+
+// ReadRequestsAsMap is like ReadRequests but takes in a set and returns a
+// mapping.
+func ReadRequestsAsMap[T any](ctx context.Context, conn BrokerConn, urls map[string]struct{}) (results map[string]T, err error) {
+	return ReadRequestsAsMapWithOptions[T](ctx, conn, urls, DefaultBatchOptions)
+}
+
+// ReadRequestsAsMapWithOptions is like ReadRequestsAsMap() with custom
+// options.
+//
+// If opts.CancelOnError is false, the mapping contains every successful
+// result even if a *BatchError is returned.
+func ReadRequestsAsMapWithOptions[T any](
+	ctx context.Context,
+	conn BrokerConn,
+	urls map[string]struct{},
+	opts BatchOptions,
+) (results map[string]T, err error) {
+	orderedURLs := make([]string, 0, len(urls))
+	for url := range urls {
+		orderedURLs = append(orderedURLs, url)
+	}
+	orderedOut := make([]T, len(orderedURLs))
+	err = ReadRequestsWithOptions(ctx, conn, orderedURLs, orderedOut, opts)
+
+	var failures map[string]error
+	if err != nil {
+		var batchErr *BatchError
+		if opts.CancelOnError || !errors.As(err, &batchErr) {
+			return nil, err
+		}
+		failures = batchErr.Failures
+	}
+
+	results = map[string]T{}
+	for i, url := range orderedURLs {
+		if _, failed := failures[url]; !failed {
+			results[url] = orderedOut[i]
+		}
+	}
+	return results, err
+}
+
+// CreateRequest sends a CreateRequest to the given URL with the provided body.
+// It returns an error if the send fails.
+//
+// This does not wait for the bridge to respond, so a nil error does not mean
+// that the command was accepted. See AckCreateRequest.
+func CreateRequest(ctx context.Context, conn BrokerConn, url string, body any) (err error) {
+	defer essentials.AddCtxTo("request "+url, &err)
+
+	msg, err := newRequest("CreateRequest", url, body)
+	if err != nil {
+		return err
+	}
+	return conn.Send(msg)
+}
+
+// AckCreateRequest is like CreateRequest, but waits for the bridge to respond
+// to the request.
+//
+// If the bridge rejects the request, a *LEAPError is returned. The context
+// should typically have a timeout, since some bridges never respond to
+// certain requests.
+//
+// The request is not retried, since repeating a command may not be safe.
+// See AckCreateRequestWithRetry for idempotent commands.
+func AckCreateRequest(ctx context.Context, conn BrokerConn, url string, body any) error {
+	return AckCreateRequestWithRetry(ctx, conn, url, body, NoRetry)
+}
+
+// AckCreateRequestWithRetry is like AckCreateRequest, but retries transient
+// failures according to policy.
+//
+// This should only be used for idempotent commands, since a command may take
+// effect even if its response is lost.
+func AckCreateRequestWithRetry(
+	ctx context.Context,
+	conn BrokerConn,
+	url string,
+	body any,
+	policy RetryPolicy,
+) (err error) {
+	defer essentials.AddCtxTo("request "+url, &err)
+
+	return policy.Do(ctx, func(ctx context.Context) error {
+		msg, err := newRequest("CreateRequest", url, body)
+		if err != nil {
+			return err
+		}
+		_, err = callRequest(ctx, conn, msg)
+		return err
+	})
+}
+
+// newRequest creates a message with a unique ClientTag.
+//
+// If body is nil, the message will have no body.
+func newRequest(communiqueType, url string, body any) (Message, error) {
+	var encoded json.RawMessage
+	if body != nil {
+		var err error
+		encoded, err = json.Marshal(body)
+		if err != nil {
+			return Message{}, err
+		}
+	}
+	uuid, err := uuid.NewUUID()
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		CommuniqueType: communiqueType,
+		Header: Header{
+			ClientTag: uuid.String(),
+			Url:       url,
+		},
+		Body: encoded,
+	}, nil
+}
+
+// callRequest sends a message and waits for the response with a matching
+// ClientTag, converting error statuses into a *LEAPError.
+//
+// If the error is a *LEAPError, the response is returned as well.
+func callRequest(ctx context.Context, conn BrokerConn, msg Message) (Message, error) {
+	clientTag := msg.Header.ClientTag
+	response, err := conn.Call(ctx, msg, func(response Message) (bool, error) {
+		return response.Header.ClientTag == clientTag, nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	if leapErr := newLEAPError(response); leapErr != nil {
+		return response, leapErr
+	}
+	return response, nil
+}
+
+// RawRequest sends a request of any type and returns the raw response.
+//
+// If the bridge responds with an error status, the response is returned along
+// with a *LEAPError. ReadRequests are retried according to DefaultRetryPolicy,
+// but other requests are never retried.
+func RawRequest(
+	ctx context.Context,
+	conn BrokerConn,
+	communiqueType string,
+	url string,
+	body any,
+) (response Message, err error) {
+	defer essentials.AddCtxTo("request "+url, &err)
+
+	policy := NoRetry
+	if communiqueType == "ReadRequest" {
+		policy = DefaultRetryPolicy
+	}
+	err = policy.Do(ctx, func(ctx context.Context) error {
+		msg, err := newRequest(communiqueType, url, body)
+		if err != nil {
+			return err
+		}
+		response, err = callRequest(ctx, conn, msg)
+		return err
+	})
+	return response, err
+}
+
+// UpdateRequest sends an UpdateRequest to the given URL with the provided body
+// and waits for the bridge to respond.
+//
+// If result is non-nil, the response body is parsed into it.
+func UpdateRequest(ctx context.Context, conn BrokerConn, url string, body any, result any) (err error) {
+	defer essentials.AddCtxTo("request "+url, &err)
+
+	msg, err := newRequest("UpdateRequest", url, body)
+	if err != nil {
+		return err
+	}
+	response, err := callRequest(ctx, conn, msg)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(response.Body), result)
+}
+
+// DeleteRequest sends a DeleteRequest to the given URL and waits for the
+// bridge to respond.
+func DeleteRequest(ctx context.Context, conn BrokerConn, url string) (err error) {
+	defer essentials.AddCtxTo("request "+url, &err)
+
+	msg, err := newRequest("DeleteRequest", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = callRequest(ctx, conn, msg)
+	return err
+}
+
+// SubscribeRequest subscribes to the given URL and returns a channel of
+// decoded updates.
+//
+// The first value on the channel is the initial state from the subscribe
+// response. Later values come from messages which the bridge sends with the
+// same ClientTag as the subscription.
+//
+// The channel is closed when the context is done or the connection is closed.
+// Messages that cannot be decoded into T are skipped.
+func SubscribeRequest[T any](ctx context.Context, conn BrokerConn, url string) (updates <-chan T, err error) {
+	defer essentials.AddCtxTo("subscribe "+url, &err)
+
+	msg, err := newRequest("SubscribeRequest", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	clientTag := msg.Header.ClientTag
+
+	ctx, cancel := context.WithCancel(ctx)
+	incoming := make(chan Message, 16)
+	subErr := make(chan error, 1)
+	go func() {
+		defer cancel()
+		subErr <- conn.Subscribe(ctx, incoming, func() error {
+			return conn.Send(msg)
+		})
+	}()
+
+	var initial T
+	for gotInitial := false; !gotInitial; {
+		select {
+		case response := <-incoming:
+			if response.Header.ClientTag != clientTag {
+				continue
+			}
+			if leapErr := newLEAPError(response); leapErr != nil {
+				cancel()
+				return nil, leapErr
+			}
+			if err := json.Unmarshal([]byte(response.Body), &initial); err != nil {
+				cancel()
+				return nil, err
+			}
+			gotInitial = true
+		case <-ctx.Done():
+			select {
+			case err := <-subErr:
+				return nil, err
+			default:
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	results := make(chan T, 16)
+	results <- initial
+	go func() {
+		defer close(results)
+		for {
+			select {
+			case response := <-incoming:
+				if response.Header.ClientTag != clientTag {
+					continue
+				}
+				var update T
+				if err := json.Unmarshal([]byte(response.Body), &update); err != nil {
+					continue
+				}
+				select {
+				case results <- update:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results, nil
+}
@@ -0,0 +1,217 @@
+package leap_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestUpdateAndDeleteRequest(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	var updated struct {
+		Zone struct {
+			Name string
+		}
+	}
+	body := map[string]any{"Zone": map[string]any{"Name": "Counter"}}
+	if err := leap.UpdateRequest(ctx, broker, "/zone/1", body, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Zone.Name != "Counter" {
+		t.Errorf("unexpected update response: %+v", updated)
+	}
+
+	var read struct {
+		Zone struct {
+			Name        string
+			ControlType string
+		}
+	}
+	if err := leap.ReadRequest(ctx, broker, "/zone/1", &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.Zone.Name != "Counter" || read.Zone.ControlType != "Dimmed" {
+		t.Errorf("unexpected zone after update: %+v", read)
+	}
+
+	if err := leap.UpdateRequest(ctx, broker, "/zone/99", body, nil); !errors.Is(err, leap.ErrNotFound) {
+		t.Errorf("expected not found error but got %v", err)
+	}
+
+	if err := leap.DeleteRequest(ctx, broker, "/zone/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := leap.ReadRequest(ctx, broker, "/zone/1", &read); !errors.Is(err, leap.ErrNotFound) {
+		t.Errorf("expected not found error after delete but got %v", err)
+	}
+}
+
+func TestSubscribeRequest(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type statusUpdate struct {
+		ZoneStatus   *leap.ZoneStatus
+		ZoneStatuses []leap.ZoneStatus
+	}
+	updates, err := leap.SubscribeRequest[statusUpdate](ctx, broker, "/zone/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := receiveUpdate(t, updates)
+	if len(initial.ZoneStatuses) != 3 {
+		t.Fatalf("unexpected initial state: %+v", initial)
+	}
+
+	if err := leap.AckCreateRequest(ctx, broker, "/zone/1/commandprocessor", map[string]any{
+		"Command": map[string]any{
+			"CommandType":           "GoToDimmedLevel",
+			"DimmedLevelParameters": map[string]any{"Level": 25},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	update := receiveUpdate(t, updates)
+	if update.ZoneStatus == nil || update.ZoneStatus.Zone.Href != "/zone/1" || update.ZoneStatus.Level != 25 {
+		t.Fatalf("unexpected update: %+v", update)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		if ok {
+			t.Error("unexpected update after cancel")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("updates channel was not closed")
+	}
+
+	if _, err := leap.SubscribeRequest[statusUpdate](context.Background(), broker, "/zone/99/status"); !errors.Is(err, leap.ErrNotFound) {
+		t.Errorf("expected not found error but got %v", err)
+	}
+}
+
+func receiveUpdate[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case x, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed unexpectedly")
+		}
+		return x
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for update")
+	}
+	panic("unreachable")
+}
+
+func TestReadRequestsParallelism(t *testing.T) {
+	house := &leaptest.House{
+		Devices: []*leaptest.Device{
+			{Href: "/device/1", FullyQualifiedName: []string{"Module"}, DeviceType: "GrafikEyeQSModule"},
+		},
+	}
+	urls := make([]string, 20)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("/zone/%d", i+1)
+		house.Devices[0].Zones = append(house.Devices[0].Zones, &leaptest.Zone{
+			Href:        urls[i],
+			ControlType: "Dimmed",
+		})
+	}
+	broker := leaptest.NewBroker(house)
+	defer broker.Close()
+	broker.SetLatency(time.Millisecond * 10)
+
+	results := make([]any, len(urls))
+	opts := leap.BatchOptions{MaxParallel: 3, CancelOnError: true}
+	if err := leap.ReadRequestsWithOptions(context.Background(), broker, urls, results, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.MaxInFlight(); n > 3 {
+		t.Errorf("expected at most 3 requests in flight but got %d", n)
+	}
+	for i, result := range results {
+		if result == nil {
+			t.Errorf("missing result %d", i)
+		}
+	}
+}
+
+func TestReadRequestsPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+	broker.FailURL("/zone/2", "500 InternalServerError")
+
+	urls := map[string]struct{}{"/zone/1": {}, "/zone/2": {}, "/zone/3": {}}
+	opts := leap.BatchOptions{MaxParallel: 2}
+	results, err := leap.ReadRequestsAsMapWithOptions[any](ctx, broker, urls, opts)
+	var batchErr *leap.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected BatchError but got %v", err)
+	}
+	if batchErr.Total != 3 || len(batchErr.Failures) != 1 || batchErr.Failures["/zone/2"] == nil {
+		t.Errorf("unexpected failures: %+v", batchErr)
+	}
+	if !errors.Is(err, leap.ErrServerError) {
+		t.Error("BatchError should wrap the underlying error")
+	}
+	if len(results) != 2 || results["/zone/1"] == nil || results["/zone/3"] == nil {
+		t.Errorf("unexpected partial results: %v", results)
+	}
+
+	opts.CancelOnError = true
+	if _, err := leap.ReadRequestsAsMapWithOptions[any](ctx, broker, urls, opts); !errors.Is(err, leap.ErrServerError) {
+		t.Errorf("expected first error but got %v", err)
+	} else if errors.As(err, &batchErr) {
+		t.Error("did not expect BatchError with CancelOnError")
+	}
+
+	empty := &leap.BatchError{Total: 3}
+	if msg := empty.Error(); msg != "0 of 3 requests failed" {
+		t.Errorf("unexpected message for empty BatchError: %s", msg)
+	}
+}
+
+func TestGetProgrammingModelsPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+	broker.FailURL("/dimmedlevelassignment/1", "500 InternalServerError")
+
+	cache := leap.NewMemoryCache()
+	models, err := leap.GetProgrammingModels(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if models["/button/10/programmingmodel"].Preset != nil {
+		t.Error("incomplete preset should be omitted")
+	}
+	if models["/button/11/programmingmodel"].Preset == nil {
+		t.Error("complete preset should be present")
+	}
+
+	broker.FailURL("/dimmedlevelassignment/1", "")
+	models, err = leap.GetProgrammingModels(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if models["/button/10/programmingmodel"].Preset == nil {
+		t.Error("preset should be fetched once the failure is resolved")
+	}
+	if n := broker.RequestCount("/preset/2"); n != 1 {
+		t.Errorf("complete preset should be cached, but was read %d times", n)
+	}
+}
@@ -0,0 +1,58 @@
+package leap
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Cache is an interface for a KV store used to avoid re-fetching resources
+// that rarely change, such as presets.
+type Cache interface {
+	GetCache(key string, out any) bool
+	SetCache(key string, obj any)
+	ClearCache()
+}
+
+// MemoryCache is a Cache which is not persisted.
+//
+// Methods are safe to call concurrently from multiple Goroutines.
+type MemoryCache struct {
+	lock  sync.Mutex
+	cache map[string]json.RawMessage
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{cache: map[string]json.RawMessage{}}
+}
+
+// GetCache gets an object stored under the given key.
+func (m *MemoryCache) GetCache(key string, out any) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if obj, ok := m.cache[key]; !ok {
+		return false
+	} else {
+		if err := json.Unmarshal(obj, out); err != nil {
+			panic("get cache error: " + err.Error())
+		}
+		return true
+	}
+}
+
+// SetCache updates an object stored under the given key.
+func (m *MemoryCache) SetCache(key string, obj any) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	encoded, err := json.Marshal(obj)
+	if err != nil {
+		panic("set cache error: " + err.Error())
+	}
+	m.cache[key] = encoded
+}
+
+// ClearCache removes all objects from the cache.
+func (m *MemoryCache) ClearCache() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cache = map[string]json.RawMessage{}
+}
@@ -0,0 +1,192 @@
+package leap
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/unixpickle/essentials"
+)
+
+// A Client provides typed access to the resources of a LEAP bridge.
+//
+// Presets are slow to fetch and rarely change, so they are kept in Cache.
+type Client struct {
+	Conn  BrokerConn
+	Cache Cache
+
+	// Logger, if non-nil, receives errors which do not cause a request to
+	// fail, such as presets which could not be loaded.
+	Logger *log.Logger
+}
+
+// NewClient creates a Client for a connection.
+//
+// If cache is nil, presets are cached in memory.
+func NewClient(conn BrokerConn, cache Cache) *Client {
+	if cache == nil {
+		cache = NewMemoryCache()
+	}
+	return &Client{Conn: conn, Cache: cache}
+}
+
+// Devices reads every device.
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var response struct {
+		Devices []Device
+	}
+	err := ReadRequest(ctx, c.Conn, "/device", &response)
+	return response.Devices, err
+}
+
+// Device reads a single device, e.g. "/device/5".
+func (c *Client) Device(ctx context.Context, href string) (*Device, error) {
+	var response struct {
+		Device Device
+	}
+	if err := ReadRequest(ctx, c.Conn, href, &response); err != nil {
+		return nil, err
+	}
+	return &response.Device, nil
+}
+
+// Zones reads every zone.
+func (c *Client) Zones(ctx context.Context) ([]Zone, error) {
+	var response struct {
+		Zones []Zone
+	}
+	err := ReadRequest(ctx, c.Conn, "/zone", &response)
+	return response.Zones, err
+}
+
+// Zone reads a single zone, e.g. "/zone/1".
+func (c *Client) Zone(ctx context.Context, href string) (*Zone, error) {
+	var response struct {
+		Zone Zone
+	}
+	if err := ReadRequest(ctx, c.Conn, href, &response); err != nil {
+		return nil, err
+	}
+	return &response.Zone, nil
+}
+
+// ZoneStatuses reads the status of every zone.
+func (c *Client) ZoneStatuses(ctx context.Context) ([]ZoneStatus, error) {
+	var response struct {
+		ZoneStatuses []ZoneStatus
+	}
+	err := ReadRequest(ctx, c.Conn, "/zone/status", &response)
+	return response.ZoneStatuses, err
+}
+
+// ZoneStatus reads the status of a single zone, given the zone's href.
+func (c *Client) ZoneStatus(ctx context.Context, zoneHref string) (*ZoneStatus, error) {
+	var response struct {
+		ZoneStatus ZoneStatus
+	}
+	if err := ReadRequest(ctx, c.Conn, zoneHref+"/status", &response); err != nil {
+		return nil, err
+	}
+	return &response.ZoneStatus, nil
+}
+
+// Areas reads every area.
+func (c *Client) Areas(ctx context.Context) ([]Area, error) {
+	var response struct {
+		Areas []Area
+	}
+	err := ReadRequest(ctx, c.Conn, "/area", &response)
+	return response.Areas, err
+}
+
+// Area reads a single area, e.g. "/area/2".
+func (c *Client) Area(ctx context.Context, href string) (*Area, error) {
+	var response struct {
+		Area Area
+	}
+	if err := ReadRequest(ctx, c.Conn, href, &response); err != nil {
+		return nil, err
+	}
+	return &response.Area, nil
+}
+
+// Buttons reads every physical button.
+func (c *Client) Buttons(ctx context.Context) ([]Button, error) {
+	var response struct {
+		Buttons []Button
+	}
+	err := ReadRequest(ctx, c.Conn, "/button", &response)
+	return response.Buttons, err
+}
+
+// VirtualButtons reads every virtual button (i.e. scene).
+func (c *Client) VirtualButtons(ctx context.Context) ([]VirtualButton, error) {
+	var response struct {
+		VirtualButtons []VirtualButton
+	}
+	err := ReadRequest(ctx, c.Conn, "/virtualbutton", &response)
+	return response.VirtualButtons, err
+}
+
+// ProgrammingModels reads every programming model along with its presets,
+// keyed by href.
+func (c *Client) ProgrammingModels(ctx context.Context) (map[string]*ProgrammingModel, error) {
+	return getProgrammingModels(ctx, c.Conn, c.Cache, c.Logger)
+}
+
+// Preset reads a single preset and its level assignments, bypassing the
+// cache.
+func (c *Client) Preset(ctx context.Context, href string) (preset *Preset, err error) {
+	defer essentials.AddCtxTo("get preset", &err)
+	presets, err := fetchNewPresets(ctx, c.Conn, map[string]struct{}{href: {}})
+	if err != nil {
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			// Report the underlying failure rather than a batch of one.
+			return nil, errors.Join(batchErr.Unwrap()...)
+		}
+		return nil, err
+	}
+	return presets[href], nil
+}
+
+// DeviceInfos builds a summary of every device, including zone levels and
+// button programming.
+func (c *Client) DeviceInfos(ctx context.Context) ([]*DeviceInfo, error) {
+	return getDevices(ctx, c.Conn, c.Cache, c.Logger)
+}
+
+// AreaInfos builds the area tree, with the devices and zones in each area.
+func (c *Client) AreaInfos(ctx context.Context) ([]*AreaInfo, error) {
+	return getAreas(ctx, c.Conn, c.Cache, c.Logger)
+}
+
+// OccupancyGroups reads every occupancy group.
+func (c *Client) OccupancyGroups(ctx context.Context) ([]OccupancyGroup, error) {
+	var response struct {
+		OccupancyGroups []OccupancyGroup
+	}
+	err := ReadRequest(ctx, c.Conn, "/occupancygroup", &response)
+	return response.OccupancyGroups, err
+}
+
+// OccupancyGroupStatuses reads the status of every occupancy group.
+func (c *Client) OccupancyGroupStatuses(ctx context.Context) ([]OccupancyGroupStatus, error) {
+	var response struct {
+		OccupancyGroupStatuses []OccupancyGroupStatus
+	}
+	err := ReadRequest(ctx, c.Conn, "/occupancygroup/status", &response)
+	return response.OccupancyGroupStatuses, err
+}
+
+// OccupancyInfos reads the occupancy groups along with their statuses.
+func (c *Client) OccupancyInfos(ctx context.Context) ([]*OccupancyInfo, error) {
+	return GetOccupancy(ctx, c.Conn, c.Cache)
+}
+
+// logf logs to logger if it is non-nil.
+func logf(logger *log.Logger, format string, args ...any) {
+	if logger != nil {
+		logger.Printf(format, args...)
+	}
+}
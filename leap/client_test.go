@@ -0,0 +1,140 @@
+package leap_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestClientResources(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+	client := leap.NewClient(broker, nil)
+
+	devices, err := client.Devices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 5 || devices[1].Href != "/device/2" || devices[1].Name != "Island" {
+		t.Fatalf("unexpected devices: %+v", devices)
+	}
+	if devices[1].AssociatedArea == nil || devices[1].AssociatedArea.Href != "/area/2" {
+		t.Errorf("unexpected area: %+v", devices[1].AssociatedArea)
+	}
+
+	zone, err := client.Zone(ctx, "/zone/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.ControlType != "Switched" || zone.Device == nil || zone.Device.Href != "/device/3" {
+		t.Errorf("unexpected zone: %+v", zone)
+	}
+	status, err := client.ZoneStatus(ctx, "/zone/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Level != 50 || status.Zone.Href != "/zone/1" {
+		t.Errorf("unexpected zone status: %+v", status)
+	}
+
+	scenes, err := client.VirtualButtons(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenes) != 2 || !scenes[0].IsProgrammed || scenes[0].ProgrammingModel == nil {
+		t.Errorf("unexpected virtual buttons: %+v", scenes)
+	}
+
+	preset, err := client.Preset(ctx, "/preset/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preset.DimmedLevelAssignments) != 1 || len(preset.SwitchedLevelAssignments) != 1 {
+		t.Errorf("unexpected preset: %+v", preset)
+	}
+	broker.FailURL("/preset/1", "404 NotFound")
+	if _, err := client.Preset(ctx, "/preset/1"); !errors.Is(err, leap.ErrNotFound) {
+		t.Errorf("expected not found error but got %v", err)
+	}
+
+	areas, err := client.Areas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(areas) != 6 || areas[0].Parent != nil || areas[1].Parent == nil || areas[1].Parent.Href != "/area/1" {
+		t.Errorf("unexpected areas: %+v", areas)
+	}
+	area, err := client.Area(ctx, "/area/4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if area.Name != "Bedroom" || !area.IsLeaf {
+		t.Errorf("unexpected area: %+v", area)
+	}
+	if _, err := client.Area(ctx, "/area/99"); !errors.Is(err, leap.ErrNotFound) {
+		t.Errorf("expected not found error for missing area but got %v", err)
+	}
+
+	groups, err := client.OccupancyGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].AssociatedAreas) != 1 || groups[0].AssociatedAreas[0].Area.Href != "/area/2" {
+		t.Errorf("unexpected occupancy groups: %+v", groups)
+	}
+	occupancy, err := client.OccupancyGroupStatuses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(occupancy) != 1 || occupancy[0].OccupancyGroup.Href != groups[0].Href ||
+		occupancy[0].OccupancyStatus != "Unoccupied" {
+		t.Errorf("unexpected occupancy statuses: %+v", occupancy)
+	}
+
+	tree, err := client.AreaInfos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != 1 || tree[0].Name != "Home" || len(tree[0].Areas) != 2 {
+		t.Fatalf("unexpected area tree: %+v", tree)
+	}
+	downstairs, upstairs := tree[0].Areas[0], tree[0].Areas[1]
+	if downstairs.Name != "Downstairs" || len(downstairs.Areas) != 2 || upstairs.Name != "Upstairs" {
+		t.Fatalf("unexpected floors: %+v %+v", downstairs, upstairs)
+	}
+	kitchen := downstairs.Areas[0]
+	if len(kitchen.Devices) != 2 || len(kitchen.Zones) != 1 || kitchen.Zones[0].Name != "Island" {
+		t.Errorf("unexpected kitchen: %+v", kitchen)
+	}
+	// The shade upstairs is not a light, so only the island (50) and the lamp
+	// (100) count.
+	if tree[0].OnCount != 2 || tree[0].AverageLevel == nil || *tree[0].AverageLevel != 75 {
+		t.Errorf("unexpected summary: on=%d average=%v", tree[0].OnCount, tree[0].AverageLevel)
+	}
+	if upstairs.OnCount != 0 || upstairs.AverageLevel != nil || len(upstairs.Areas[0].Zones) != 1 {
+		t.Errorf("unexpected upstairs: %+v", upstairs)
+	}
+}
+
+func TestClientLogger(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+	broker.FailURL("/dimmedlevelassignment/1", "500 InternalServerError")
+
+	var buf bytes.Buffer
+	client := leap.NewClient(broker, nil)
+	client.Logger = log.New(&buf, "", 0)
+	if _, err := client.DeviceInfos(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "partially failed to fetch presets") {
+		t.Errorf("unexpected log output: %q", buf.String())
+	}
+}
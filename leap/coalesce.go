@@ -0,0 +1,63 @@
+package leap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var readCoalescer Coalescer[json.RawMessage]
+
+// CoalescedReads returns the number of ReadRequest() calls which shared the
+// response of an identical read that was already in flight.
+func CoalescedReads() int64 {
+	return readCoalescer.Coalesced()
+}
+
+// A Coalescer merges concurrent calls with the same key, so that only one of
+// them does any work and the rest wait for its result.
+type Coalescer[T any] struct {
+	group     singleflight.Group
+	coalesced atomic.Int64
+}
+
+// Do calls f, or waits for an in-flight call with the same key.
+//
+// The context passed to f is not cancelled when the caller's context is, since
+// other callers may be waiting for the result; f must limit its own duration.
+// Callers stop waiting when their own ctx is done.
+func (c *Coalescer[T]) Do(ctx context.Context, key string, f func(context.Context) (T, error)) (T, error) {
+	var leader bool
+	ch := c.group.DoChan(key, func() (any, error) {
+		leader = true
+		return f(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-ch:
+		if !leader {
+			c.coalesced.Add(1)
+		}
+		if res.Err != nil {
+			var zero T
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Coalesced returns the number of calls which waited for another call's
+// result instead of calling f themselves.
+func (c *Coalescer[T]) Coalesced() int64 {
+	return c.coalesced.Load()
+}
+
+// ConnKey creates a coalescing key which is unique to a connection.
+func ConnKey(conn BrokerConn, key string) string {
+	return fmt.Sprintf("%p %s", conn, key)
+}
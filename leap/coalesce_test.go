@@ -0,0 +1,99 @@
+package leap_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestReadRequestCoalescing(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+	broker.SetLatency(time.Millisecond * 50)
+
+	before := leap.CoalescedReads()
+	var wg sync.WaitGroup
+	results := make([]struct {
+		ZoneStatus leap.ZoneStatus
+	}, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := leap.ReadRequest(context.Background(), broker, "/zone/1/status", &results[i]); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := broker.RequestCount("/zone/1/status"); n != 1 {
+		t.Errorf("expected one read but got %d", n)
+	}
+	if n := leap.CoalescedReads() - before; n != 9 {
+		t.Errorf("expected 9 coalesced reads but got %d", n)
+	}
+	for i, result := range results {
+		if result.ZoneStatus.Level != 50 {
+			t.Errorf("result %d: unexpected level %d", i, result.ZoneStatus.Level)
+		}
+	}
+}
+
+func TestReadRequestCoalescingCancel(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+	broker.SetLatency(time.Millisecond * 50)
+
+	// The first caller giving up should not affect the other caller.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		var result any
+		errs <- leap.ReadRequest(ctx, broker, "/zone/1", &result)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	go func() {
+		var result any
+		errs <- leap.ReadRequest(context.Background(), broker, "/zone/1", &result)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+
+	if err := <-errs; err == nil {
+		t.Error("expected cancelled read to fail")
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("expected second read to succeed but got %v", err)
+	}
+}
+
+func TestReadRequestCoalescingPolicy(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+	broker.SetLatency(time.Millisecond * 50)
+
+	// A single-attempt read must not share a retrying read's request.
+	retrying := leap.RetryPolicy{MaxAttempts: 3, AttemptTimeout: time.Second}
+	single := leap.RetryPolicy{MaxAttempts: 1, AttemptTimeout: time.Second}
+	var wg sync.WaitGroup
+	for _, policy := range []leap.RetryPolicy{retrying, single} {
+		wg.Add(1)
+		go func(policy leap.RetryPolicy) {
+			defer wg.Done()
+			var result any
+			if err := leap.ReadRequestWithRetry(context.Background(), broker, "/zone/1", &result, policy); err != nil {
+				t.Error(err)
+			}
+		}(policy)
+	}
+	wg.Wait()
+
+	if n := broker.RequestCount("/zone/1"); n != 2 {
+		t.Errorf("expected one read per policy but got %d", n)
+	}
+}
@@ -0,0 +1,152 @@
+package leap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A CommandQueue serializes commands which target the same resource, such as
+// a zone, and limits how quickly commands are sent overall.
+//
+// While a command is waiting in the queue, a newer command for the same key
+// and collapse group replaces it, so that a burst of level changes only sends
+// the latest level.
+type CommandQueue struct {
+	interval  time.Duration
+	clock     clock
+	collapsed atomic.Int64
+
+	// sendLock is held while waiting for the rate limit, so that lastSend is
+	// the time the most recent command was actually sent.
+	sendLock sync.Mutex
+	lastSend time.Time
+
+	lock   sync.Mutex
+	queues map[string][]*queuedCommand
+}
+
+// clock lets tests control time.
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type queuedCommand struct {
+	f        func(context.Context) error
+	collapse string
+	results  []chan error
+}
+
+// NewCommandQueue creates a CommandQueue which waits at least interval
+// between sending any two commands. An interval of 0 disables rate limiting.
+func NewCommandQueue(interval time.Duration) *CommandQueue {
+	return &CommandQueue{
+		interval: interval,
+		clock:    realClock{},
+		queues:   map[string][]*queuedCommand{},
+	}
+}
+
+// Submit queues a command for the given key, to be sent by calling f once
+// all earlier commands for the key have finished.
+//
+// If collapse is non-empty and the most recently queued command for the key
+// has not started and has the same collapse group, it is replaced by this
+// one. Either way, the returned channel receives the result of the command
+// that was eventually sent.
+//
+// The context passed to f is never cancelled, so f must limit its own
+// duration.
+func (c *CommandQueue) Submit(key, collapse string, f func(context.Context) error) <-chan error {
+	result := make(chan error, 1)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	queue, running := c.queues[key]
+	if n := len(queue); collapse != "" && n > 0 && queue[n-1].collapse == collapse {
+		last := queue[n-1]
+		last.f = f
+		last.results = append(last.results, result)
+		c.collapsed.Add(1)
+		return result
+	}
+	c.queues[key] = append(queue, &queuedCommand{
+		f:        f,
+		collapse: collapse,
+		results:  []chan error{result},
+	})
+	if !running {
+		go c.run(key)
+	}
+	return result
+}
+
+// Collapsed returns the number of commands which were replaced by a newer
+// command before being sent.
+func (c *CommandQueue) Collapsed() int64 {
+	return c.collapsed.Load()
+}
+
+// run sends the commands for a key until its queue is empty.
+func (c *CommandQueue) run(key string) {
+	for {
+		c.waitForTurn()
+
+		c.lock.Lock()
+		queue := c.queues[key]
+		cmd := queue[0]
+		if len(queue) == 1 {
+			c.queues[key] = nil
+		} else {
+			c.queues[key] = queue[1:]
+		}
+		c.lock.Unlock()
+
+		err := cmd.f(context.Background())
+		for _, ch := range cmd.results {
+			ch <- err
+		}
+
+		c.lock.Lock()
+		if len(c.queues[key]) == 0 {
+			delete(c.queues, key)
+			c.lock.Unlock()
+			return
+		}
+		c.lock.Unlock()
+	}
+}
+
+// waitForTurn blocks until at least the interval has passed since the
+// previous command was sent, and then records that a command is being sent.
+//
+// Spacing is measured from when waiting actually finished, so a late wake-up
+// delays later commands rather than letting them bunch up.
+func (c *CommandQueue) waitForTurn() {
+	if c.interval == 0 {
+		return
+	}
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	for {
+		delay := c.lastSend.Add(c.interval).Sub(c.clock.Now())
+		if delay <= 0 {
+			break
+		}
+		c.clock.Sleep(delay)
+	}
+	c.lastSend = c.clock.Now()
+}
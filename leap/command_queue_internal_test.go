@@ -0,0 +1,51 @@
+package leap
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+
+	// oversleep is added to every Sleep, like a late wake-up.
+	oversleep time.Duration
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Sleep(d time.Duration) {
+	f.now = f.now.Add(d + f.oversleep)
+}
+
+func TestCommandQueueRateLimit(t *testing.T) {
+	interval := time.Millisecond * 20
+	start := time.Unix(1000, 0)
+	clock := &fakeClock{now: start}
+	queue := NewCommandQueue(interval)
+	queue.clock = clock
+
+	var sends []time.Time
+	for i := 0; i < 6; i++ {
+		// Every other wake-up is late.
+		clock.oversleep = 0
+		if i%2 == 1 {
+			clock.oversleep = time.Millisecond * 15
+		}
+		queue.waitForTurn()
+		sends = append(sends, clock.Now())
+
+		// Sending takes a little time.
+		clock.now = clock.now.Add(time.Millisecond)
+	}
+	if !sends[0].Equal(start) {
+		t.Errorf("first command was delayed by %v", sends[0].Sub(start))
+	}
+	for i := 1; i < len(sends); i++ {
+		if d := sends[i].Sub(sends[i-1]); d < interval {
+			t.Errorf("commands %d and %d were only %v apart", i-1, i, d)
+		}
+	}
+}
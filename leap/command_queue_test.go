@@ -0,0 +1,98 @@
+package leap_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+)
+
+func TestCommandQueueCollapse(t *testing.T) {
+	queue := leap.NewCommandQueue(0)
+
+	var lock sync.Mutex
+	var sent []int
+	release := make(chan struct{})
+	send := func(level int) func(context.Context) error {
+		return func(ctx context.Context) error {
+			if level == 0 {
+				<-release
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			sent = append(sent, level)
+			return nil
+		}
+	}
+
+	// The first command is in flight while the rest are queued behind it.
+	results := []<-chan error{queue.Submit("/zone/1", "level", send(0))}
+	time.Sleep(time.Millisecond * 10)
+	for level := 1; level <= 3; level++ {
+		results = append(results, queue.Submit("/zone/1", "level", send(level)))
+	}
+	results = append(results, queue.Submit("/zone/1", "", send(4)))
+	results = append(results, queue.Submit("/zone/1", "level", send(5)))
+	results = append(results, queue.Submit("/zone/1", "tilt", send(6)))
+	close(release)
+
+	for i, ch := range results {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Errorf("command %d: %v", i, err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timed out waiting for command %d", i)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []int{0, 3, 4, 5, 6}
+	if len(sent) != len(expected) {
+		t.Fatalf("expected %v but sent %v", expected, sent)
+	}
+	for i, level := range expected {
+		if sent[i] != level {
+			t.Fatalf("expected %v but sent %v", expected, sent)
+		}
+	}
+	if n := queue.Collapsed(); n != 2 {
+		t.Errorf("expected 2 collapsed commands but got %d", n)
+	}
+}
+
+func TestCommandQueueKeys(t *testing.T) {
+	queue := leap.NewCommandQueue(0)
+
+	var lock sync.Mutex
+	inFlight := map[string]bool{}
+	failure := errors.New("rejected")
+	var results []<-chan error
+	for i := 0; i < 6; i++ {
+		key := []string{"/zone/1", "/zone/2"}[i%2]
+		results = append(results, queue.Submit(key, "", func(ctx context.Context) error {
+			lock.Lock()
+			if inFlight[key] {
+				t.Errorf("concurrent commands for %s", key)
+			}
+			inFlight[key] = true
+			lock.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			lock.Lock()
+			inFlight[key] = false
+			lock.Unlock()
+			return failure
+		}))
+	}
+	for _, ch := range results {
+		if err := <-ch; err != failure {
+			t.Errorf("unexpected result: %v", err)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package leap
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/unixpickle/essentials"
+)
+
+type ButtonInfo struct {
+	Href             string
+	Name             string
+	ButtonNumber     int
+	ProgrammingModel *ProgrammingModel
+	LastEvent        *ButtonEvent `json:",omitempty"`
+}
+
+type DeviceInfo struct {
+	Href               string
+	Name               string
+	FullyQualifiedName []string
+	DeviceType         string
+	Area               *string `json:",omitempty"`
+
+	SerialNumber   int    `json:",omitempty"`
+	ModelNumber    string `json:",omitempty"`
+	Firmware       string `json:",omitempty"`
+	AddressedState string `json:",omitempty"`
+
+	// Occupancy is the occupancy of the device's area, if the area has
+	// occupancy sensors.
+	Occupancy string `json:",omitempty"`
+
+	// Zone and Level describe the primary zone, which is the last zone of
+	// the device with a known status; Level is nil if the zone has no level.
+	// Zones lists every zone of the device.
+	Level *int `json:",omitempty"`
+	Zone  *string
+	Zones []*ZoneInfo `json:",omitempty"`
+
+	// FanSpeed is the speed of the device's first fan zone, if it has one.
+	FanSpeed *string `json:",omitempty"`
+
+	Buttons      []*ButtonInfo `json:",omitempty"`
+	ButtonGroups []string      `json:",omitempty"`
+
+	// Battery is the battery level of battery-powered devices, and
+	// Availability is whether the bridge can reach the device, if the bridge
+	// reports them.
+	Battery      string `json:",omitempty"`
+	Availability string `json:",omitempty"`
+
+	// LastSeen is when a device status update or a button event last arrived
+	// for the device. Zone status updates are not counted, since the bridge
+	// sends them for commands even if the device is unreachable.
+	LastSeen *time.Time `json:",omitempty"`
+}
+
+// ZoneInfo summarizes a zone and its current status.
+type ZoneInfo struct {
+	Href           string
+	Name           string
+	ControlType    string
+	Level          *int   `json:",omitempty"`
+	StatusAccuracy string `json:",omitempty"`
+
+	// FanSpeed is the speed of a fan zone, in which case Level is the
+	// corresponding level.
+	FanSpeed string `json:",omitempty"`
+
+	// Tilt is the tilt of a shade, from 0 to 100, if it can tilt.
+	Tilt *int `json:",omitempty"`
+
+	// Color and Vibrancy are the color of a tunable zone, and KelvinRange is
+	// the range of color temperatures which it supports.
+	Color       *ColorTuningStatus `json:",omitempty"`
+	Vibrancy    *int               `json:",omitempty"`
+	KelvinRange *KelvinRange       `json:",omitempty"`
+
+	// HVAC is the state of an HVAC zone, which has no Level.
+	HVAC *HVACInfo `json:",omitempty"`
+}
+
+// ButtonEvent is the most recent event reported for a button.
+type ButtonEvent struct {
+	EventType string
+	Time      time.Time
+}
+
+// deviceData is the raw state needed to build a list of DeviceInfos.
+type deviceData struct {
+	Areas        []Area
+	Devices      []Device
+	Zones        []Zone
+	ZoneStatuses map[string]ZoneStatus
+	Buttons      []Button
+	ButtonEvents map[string]*ButtonEvent
+	Models       map[string]*ProgrammingModel
+
+	OccupancyGroups []OccupancyGroup
+	Occupancy       map[string]occupancyState
+
+	DeviceStatuses map[string]DeviceStatus
+	LastSeen       map[string]time.Time
+
+	// zoneDevices and buttonDevices map zones and buttons to the hrefs of
+	// their devices.
+	zoneDevices   map[string]string
+	buttonDevices map[string]string
+}
+
+func GetDevices(ctx context.Context, conn BrokerConn, cache Cache) ([]*DeviceInfo, error) {
+	return getDevices(ctx, conn, cache, nil)
+}
+
+// getDevices is like GetDevices, but reports non-fatal errors to logger.
+func getDevices(
+	ctx context.Context,
+	conn BrokerConn,
+	cache Cache,
+	logger *log.Logger,
+) (devices []*DeviceInfo, err error) {
+	defer essentials.AddCtxTo("get devices", &err)
+
+	var zoneResponse struct {
+		ZoneStatuses []ZoneStatus
+	}
+	if err := ReadRequest(ctx, conn, "/zone/status", &zoneResponse); err != nil {
+		return nil, err
+	}
+	data, err := fetchDeviceData(ctx, conn, cache, zoneResponse.ZoneStatuses, logger)
+	if err != nil {
+		return nil, err
+	}
+	return data.DeviceInfos(), nil
+}
+
+// fetchDeviceData reads everything but zone statuses, which are passed in by
+// the caller.
+func fetchDeviceData(
+	ctx context.Context,
+	conn BrokerConn,
+	cache Cache,
+	zoneStatuses []ZoneStatus,
+	logger *log.Logger,
+) (*deviceData, error) {
+	var devicesResponse struct {
+		Devices []Device
+	}
+	if err := ReadRequest(ctx, conn, "/device", &devicesResponse); err != nil {
+		return nil, err
+	}
+
+	// Areas and zones only add detail to the device list, so bridges which
+	// lack them are still usable.
+	var zonesResponse struct {
+		Zones []Zone
+	}
+	if err := readOptional(ctx, conn, "/zone", &zonesResponse); err != nil {
+		return nil, err
+	}
+	var areasResponse struct {
+		Areas []Area
+	}
+	if err := readOptional(ctx, conn, "/area", &areasResponse); err != nil {
+		return nil, err
+	}
+
+	var buttonResponse struct {
+		Buttons []Button
+	}
+	if err := ReadRequest(ctx, conn, "/button", &buttonResponse); err != nil {
+		return nil, err
+	}
+	models, err := getProgrammingModels(ctx, conn, cache, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &deviceData{
+		Areas:        areasResponse.Areas,
+		Devices:      devicesResponse.Devices,
+		Zones:        zonesResponse.Zones,
+		ZoneStatuses: map[string]ZoneStatus{},
+		Buttons:      buttonResponse.Buttons,
+		ButtonEvents: map[string]*ButtonEvent{},
+		Models:       models,
+		Occupancy:    map[string]occupancyState{},
+
+		DeviceStatuses: map[string]DeviceStatus{},
+		LastSeen:       map[string]time.Time{},
+	}
+	for _, zone := range zoneStatuses {
+		data.ZoneStatuses[zone.Zone.Href] = zone
+	}
+	data.indexDevices()
+
+	var statusResponse deviceStatusUpdate
+	if err := readOptional(ctx, conn, "/device/status", &statusResponse); err != nil {
+		return nil, err
+	}
+	data.applyDeviceStatuses(statusResponse, false)
+	if err := data.fetchOccupancy(ctx, conn); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readOptional is like ReadRequest, but treats a missing resource as empty.
+func readOptional(ctx context.Context, conn BrokerConn, url string, result any) error {
+	err := ReadRequest(ctx, conn, url, result)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// DeviceInfos builds the device list from the raw data.
+func (d *deviceData) DeviceInfos() []*DeviceInfo {
+	buttonGroupToButtons := map[string][]*ButtonInfo{}
+	for _, button := range d.Buttons {
+		key := button.Parent.Href
+		buttonInfo := &ButtonInfo{
+			Href:             button.Href,
+			Name:             button.Name,
+			ButtonNumber:     button.ButtonNumber,
+			ProgrammingModel: d.Models[button.ProgrammingModel.Href],
+			LastEvent:        d.ButtonEvents[button.Href],
+		}
+		buttonGroupToButtons[key] = append(buttonGroupToButtons[key], buttonInfo)
+	}
+
+	zones := map[string]Zone{}
+	for _, zone := range d.Zones {
+		zones[zone.Href] = zone
+	}
+
+	var devices []*DeviceInfo
+	for _, device := range d.Devices {
+		outDev := &DeviceInfo{
+			Href:               device.Href,
+			Name:               device.Name,
+			FullyQualifiedName: device.FullyQualifiedName,
+			DeviceType:         device.DeviceType,
+			SerialNumber:       device.SerialNumber,
+			ModelNumber:        device.ModelNumber,
+			AddressedState:     device.AddressedState,
+		}
+		if device.FirmwareImage != nil {
+			outDev.Firmware = device.FirmwareImage.Firmware.DisplayName
+		}
+		if status, ok := d.DeviceStatuses[device.Href]; ok {
+			if status.BatteryStatus != nil {
+				outDev.Battery = status.BatteryStatus.LevelState
+			}
+			outDev.Availability = status.Availability
+		}
+		if lastSeen, ok := d.LastSeen[device.Href]; ok {
+			outDev.LastSeen = &lastSeen
+		}
+		if device.AssociatedArea != nil {
+			outDev.Area = &device.AssociatedArea.Href
+			outDev.Occupancy = d.areaOccupancy(device.AssociatedArea.Href)
+		}
+		for _, link := range device.LocalZones {
+			zone, ok := zones[link.Href]
+			if !ok {
+				zone = Zone{Href: link.Href}
+			}
+			info := d.zoneInfo(zone)
+			outDev.Zones = append(outDev.Zones, info)
+			if _, ok := d.ZoneStatuses[info.Href]; ok {
+				outDev.Zone = &info.Href
+				outDev.Level = info.Level
+			}
+			if outDev.FanSpeed == nil && info.FanSpeed != "" {
+				outDev.FanSpeed = &info.FanSpeed
+			}
+		}
+		for _, buttonGroup := range device.ButtonGroups {
+			outDev.Buttons = append(outDev.Buttons, buttonGroupToButtons[buttonGroup.Href]...)
+			outDev.ButtonGroups = append(outDev.ButtonGroups, buttonGroup.Href)
+		}
+		devices = append(devices, outDev)
+	}
+
+	return devices
+}
+
+type deviceStatusUpdate struct {
+	DeviceStatus   *DeviceStatus
+	DeviceStatuses []DeviceStatus
+}
+
+// indexDevices maps zones and buttons to their devices.
+func (d *deviceData) indexDevices() {
+	groupDevices := map[string]string{}
+	d.zoneDevices = map[string]string{}
+	for _, device := range d.Devices {
+		for _, zone := range device.LocalZones {
+			d.zoneDevices[zone.Href] = device.Href
+		}
+		for _, group := range device.ButtonGroups {
+			groupDevices[group.Href] = device.Href
+		}
+	}
+	d.buttonDevices = map[string]string{}
+	for _, button := range d.Buttons {
+		if device, ok := groupDevices[button.Parent.Href]; ok {
+			d.buttonDevices[button.Href] = device
+		}
+	}
+}
+
+// applyDeviceStatuses records device statuses. If seen is true, the devices
+// are marked as seen.
+func (d *deviceData) applyDeviceStatuses(update deviceStatusUpdate, seen bool) {
+	statuses := update.DeviceStatuses
+	if update.DeviceStatus != nil {
+		statuses = append(statuses, *update.DeviceStatus)
+	}
+	for _, status := range statuses {
+		d.DeviceStatuses[status.Device.Href] = status
+		if seen {
+			d.markSeen(status.Device.Href)
+		}
+	}
+}
+
+// markSeen records that a device was just heard from.
+func (d *deviceData) markSeen(deviceHref string) {
+	if deviceHref != "" {
+		d.LastSeen[deviceHref] = time.Now()
+	}
+}
+
+// zoneInfo combines a zone with its status, if known.
+func (d *deviceData) zoneInfo(zone Zone) *ZoneInfo {
+	info := &ZoneInfo{
+		Href:        zone.Href,
+		Name:        zone.Name,
+		ControlType: zone.ControlType,
+	}
+	if zone.ColorTuningProperties != nil {
+		info.KelvinRange = zone.ColorTuningProperties.WhiteTuningLevelRange
+	}
+	if status, ok := d.ZoneStatuses[zone.Href]; ok && zone.ControlType == "HVAC" {
+		info.HVAC = newHVACInfo(status)
+		info.StatusAccuracy = status.StatusAccuracy
+	} else if ok {
+		level := status.Level
+		if fanLevel, ok := FanSpeedLevel(status.FanSpeed); ok {
+			info.FanSpeed = status.FanSpeed
+			level = fanLevel
+		}
+		info.Level = &level
+		info.Tilt = status.Tilt
+		info.Color = status.ColorTuningStatus
+		info.Vibrancy = status.Vibrancy
+		info.StatusAccuracy = status.StatusAccuracy
+	}
+	return info
+}
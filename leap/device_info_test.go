@@ -0,0 +1,155 @@
+package leap_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestDeviceInfosMultiZone(t *testing.T) {
+	ctx := context.Background()
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Ceiling Fan"},
+		DeviceType:         "FanSpeedController",
+		Area:               "/area/4",
+		Zones: []*leaptest.Zone{
+			{Href: "/zone/4", Name: "Fan Light", ControlType: "Dimmed", Level: 30},
+			{Href: "/zone/5", Name: "Fan", ControlType: "FanSpeed", Level: 0},
+		},
+	})
+	broker := leaptest.NewBroker(house)
+	defer broker.Close()
+
+	state := leap.NewLiveState()
+	defer state.Invalidate()
+	cache := leap.NewMemoryCache()
+	devices, err := state.Devices(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fan := findDevice(devices, "Ceiling Fan")
+	if len(fan.Zones) != 2 {
+		t.Fatalf("expected 2 zones but got %+v", fan.Zones)
+	}
+	light, motor := fan.Zones[0], fan.Zones[1]
+	if light.Href != "/zone/4" || light.Name != "Fan Light" || light.ControlType != "Dimmed" ||
+		light.Level == nil || *light.Level != 30 || light.StatusAccuracy != "Good" {
+		t.Errorf("unexpected light zone: %+v", light)
+	}
+	if motor.Href != "/zone/5" || motor.ControlType != "FanSpeed" || motor.Level == nil ||
+		*motor.Level != 0 || motor.FanSpeed != "Off" {
+		t.Errorf("unexpected fan zone: %+v", motor)
+	}
+	if fan.FanSpeed == nil || *fan.FanSpeed != "Off" {
+		t.Errorf("unexpected device fan speed: %v", fan.FanSpeed)
+	}
+
+	// The last zone with a known status is the primary zone.
+	if fan.Zone == nil || *fan.Zone != "/zone/5" || fan.Level == nil || *fan.Level != 0 {
+		t.Errorf("unexpected primary zone: %v %v", fan.Zone, fan.Level)
+	}
+
+	broker.SetZoneLevel("/zone/5", 75)
+	leaptest.WaitFor(t, func() bool {
+		devices, _ := state.Devices(ctx, broker, cache)
+		fan := findDevice(devices, "Ceiling Fan")
+		return *fan.Zones[1].Level == 75 && *fan.Level == 75 && *fan.FanSpeed == "MediumHigh"
+	})
+
+	island := findDevice(devices, "Island")
+	if len(island.Zones) != 1 || island.Zones[0].Href != *island.Zone {
+		t.Errorf("unexpected single-zone device: %+v", island)
+	}
+}
+
+func TestDeviceInfosMetadata(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	devices, err := leap.GetDevices(context.Background(), broker, leap.NewMemoryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	island := findDevice(devices, "Island")
+	if island.Href != "/device/2" || island.Name != "Island" || island.SerialNumber != 12345678 ||
+		island.ModelNumber != "PD-6WCL-XX" || island.Firmware != "001.005.000r000" ||
+		island.AddressedState != "Addressed" {
+		t.Errorf("unexpected island metadata: %+v", island)
+	}
+	pico := findDevice(devices, "Pico")
+	if len(pico.ButtonGroups) != 1 || pico.ButtonGroups[0] != "/device/5/buttongroup" {
+		t.Errorf("unexpected pico button groups: %v", pico.ButtonGroups)
+	}
+	if pico.SerialNumber != 0 || pico.Firmware != "" {
+		t.Errorf("unexpected pico metadata: %+v", pico)
+	}
+}
+
+func TestDeviceInfosHVAC(t *testing.T) {
+	ctx := context.Background()
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Thermostat"},
+		DeviceType:         "Thermostat",
+		Area:               "/area/4",
+		Zones: []*leaptest.Zone{
+			{
+				Href:        "/zone/4",
+				Name:        "Thermostat",
+				ControlType: "HVAC",
+				HVAC: &leaptest.HVAC{
+					Temperature:    21.5,
+					HeatSetPoint:   20,
+					CoolSetPoint:   25,
+					OperatingMode:  "Heat",
+					FanMode:        "Auto",
+					ScheduleStatus: "Following",
+				},
+			},
+		},
+	})
+	broker := leaptest.NewBroker(house)
+	defer broker.Close()
+
+	state := leap.NewLiveState()
+	defer state.Invalidate()
+	cache := leap.NewMemoryCache()
+	devices, err := state.Devices(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thermostat := findDevice(devices, "Thermostat")
+	if thermostat.Zone == nil || *thermostat.Zone != "/zone/4" || thermostat.Level != nil {
+		t.Errorf("unexpected primary zone: %v", thermostat.Zone)
+	}
+	zone := thermostat.Zones[0]
+	if zone.Level != nil || zone.HVAC == nil {
+		t.Fatalf("unexpected HVAC zone: %+v", zone)
+	}
+	expected := leap.HVACInfo{
+		CurrentTemperature: &leap.Temperature{Celsius: 21.5, Fahrenheit: 70.7},
+		HeatSetPoint:       &leap.Temperature{Celsius: 20, Fahrenheit: 68},
+		CoolSetPoint:       &leap.Temperature{Celsius: 25, Fahrenheit: 77},
+		OperatingMode:      "Heat",
+		FanMode:            "Auto",
+		ScheduleStatus:     "Following",
+	}
+	if !reflect.DeepEqual(*zone.HVAC, expected) {
+		t.Errorf("unexpected HVAC state: %+v", zone.HVAC)
+	}
+
+	areas, err := state.Areas(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bedroom := areas[0].Areas[1].Areas[0]
+	if bedroom.Name != "Bedroom" || len(bedroom.Zones) != 2 || bedroom.Zones[1].HVAC == nil {
+		t.Errorf("unexpected bedroom zones: %+v", bedroom.Zones)
+	}
+}
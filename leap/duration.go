@@ -0,0 +1,43 @@
+package leap
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// MaxDuration is the longest fade or delay time that bridges accept.
+const MaxDuration = time.Hour * 4
+
+// FormatDuration formats a duration like LEAP fade and delay times, e.g.
+// "00:01:30" for 90 seconds.
+//
+// The duration must be a whole number of seconds from 0 to MaxDuration.
+func FormatDuration(d time.Duration) (string, error) {
+	if d < 0 || d > MaxDuration {
+		return "", fmt.Errorf("duration %v is out of range", d)
+	}
+	if d%time.Second != 0 {
+		return "", fmt.Errorf("duration %v is not a whole number of seconds", d)
+	}
+	secs := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", secs/3600, (secs/60)%60, secs%60), nil
+}
+
+var durationPattern = regexp.MustCompile(`^([0-9]{2,}):([0-5][0-9]):([0-5][0-9])$`)
+
+// ParseDuration parses a LEAP fade or delay time, such as "00:00:02".
+func ParseDuration(s string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(s)
+	if match == nil {
+		return 0, errors.New("invalid LEAP duration: " + s)
+	}
+	var parts [3]int
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(match[i+1])
+	}
+	return time.Duration(parts[0])*time.Hour + time.Duration(parts[1])*time.Minute +
+		time.Duration(parts[2])*time.Second, nil
+}
@@ -0,0 +1,107 @@
+package leap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrServerError  = errors.New("server error")
+)
+
+// A LEAPError is returned when the bridge responds to a request with a
+// non-success status code.
+//
+// It wraps one of ErrBadRequest, ErrUnauthorized, ErrNotFound, or
+// ErrServerError, so that callers can use errors.Is() to check the category.
+type LEAPError struct {
+	Url        string
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+// newLEAPError creates an error from a response if the response does not
+// indicate success.
+func newLEAPError(response Message) *LEAPError {
+	code, status := parseStatusCode(response.Header.StatusCode)
+	if code == 0 {
+		if response.Header.MessageBodyType != "ExceptionDetail" &&
+			response.CommuniqueType != "ExceptionResponse" {
+			return nil
+		}
+		code = http.StatusInternalServerError
+	} else if code < 300 {
+		return nil
+	}
+	res := &LEAPError{
+		Url:        response.Header.Url,
+		StatusCode: code,
+		Status:     status,
+	}
+	var detail struct {
+		Message string
+	}
+	if json.Unmarshal(response.Body, &detail) == nil {
+		res.Message = detail.Message
+	}
+	return res
+}
+
+func (l *LEAPError) Error() string {
+	msg := fmt.Sprintf("LEAP status %d", l.StatusCode)
+	if l.Status != "" {
+		msg += " " + l.Status
+	}
+	if l.Message != "" {
+		msg += ": " + l.Message
+	}
+	return msg
+}
+
+func (l *LEAPError) Unwrap() error {
+	switch {
+	case l.StatusCode == http.StatusUnauthorized || l.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case l.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case l.StatusCode >= 400 && l.StatusCode < 500:
+		return ErrBadRequest
+	default:
+		return ErrServerError
+	}
+}
+
+// HTTPStatus returns the HTTP status code which best describes this error,
+// for servers that expose LEAP resources over HTTP.
+func (l *LEAPError) HTTPStatus() int {
+	switch l.Unwrap() {
+	case ErrUnauthorized:
+		return http.StatusUnauthorized
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrBadRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseStatusCode parses a LEAP status like "404 NotFound".
+//
+// Returns a zero code if the status is missing or malformed.
+func parseStatusCode(status string) (int, string) {
+	codeStr, rest, _ := strings.Cut(status, " ")
+	code, err := strconv.Atoi(codeStr)
+	if err != nil {
+		return 0, ""
+	}
+	return code, rest
+}
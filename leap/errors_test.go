@@ -0,0 +1,42 @@
+package leap_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestReadRequestLEAPErrors(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	var result any
+	err := leap.ReadRequest(ctx, broker, "/area/123", &result)
+	var leapErr *leap.LEAPError
+	if !errors.As(err, &leapErr) {
+		t.Fatalf("expected LEAPError but got %v", err)
+	}
+	if !errors.Is(err, leap.ErrNotFound) || leapErr.StatusCode != 404 || leapErr.Url != "/area/123" {
+		t.Errorf("unexpected error: %#v", leapErr)
+	}
+	if leapErr.Message != "The specified resource does not exist" {
+		t.Errorf("unexpected message: %q", leapErr.Message)
+	}
+
+	for status, expected := range map[string]error{
+		"400 BadRequest":          leap.ErrBadRequest,
+		"401 Unauthorized":        leap.ErrUnauthorized,
+		"405 MethodNotAllowed":    leap.ErrBadRequest,
+		"500 InternalServerError": leap.ErrServerError,
+	} {
+		broker.FailURL("/device", status)
+		err := leap.ReadRequest(ctx, broker, "/device", &result)
+		if !errors.Is(err, expected) {
+			t.Errorf("%s: expected %v but got %v", status, expected, err)
+		}
+	}
+}
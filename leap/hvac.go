@@ -0,0 +1,148 @@
+package leap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// MinSetPoint and MaxSetPoint bound the setpoints accepted by
+// SetPointCommand, in Celsius.
+const (
+	MinSetPoint = 5.0
+	MaxSetPoint = 35.0
+)
+
+// HVACOperatingModes are the operating modes of HVAC zones.
+var HVACOperatingModes = []string{"Off", "Heat", "Cool", "Auto", "EmergencyHeat"}
+
+// HVACFanModes are the fan modes of HVAC zones.
+var HVACFanModes = []string{"Auto", "On", "Circulate"}
+
+// A Temperature is reported in both Celsius and Fahrenheit, rounded to a
+// tenth of a degree.
+type Temperature struct {
+	Celsius    float64
+	Fahrenheit float64
+}
+
+// NewTemperature creates a Temperature from degrees Celsius.
+func NewTemperature(celsius float64) *Temperature {
+	return &Temperature{
+		Celsius:    roundTenth(celsius),
+		Fahrenheit: roundTenth(CelsiusToFahrenheit(celsius)),
+	}
+}
+
+// CelsiusToFahrenheit converts a temperature from Celsius to Fahrenheit.
+func CelsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+// FahrenheitToCelsius converts a temperature from Fahrenheit to Celsius.
+func FahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+func roundTenth(x float64) float64 {
+	return math.Round(x*10) / 10
+}
+
+// HVACInfo summarizes the state of an HVAC zone.
+type HVACInfo struct {
+	CurrentTemperature *Temperature `json:",omitempty"`
+	HeatSetPoint       *Temperature `json:",omitempty"`
+	CoolSetPoint       *Temperature `json:",omitempty"`
+
+	OperatingMode string `json:",omitempty"`
+	FanMode       string `json:",omitempty"`
+
+	// ScheduleStatus is "Following" if the thermostat is following its
+	// schedule, or a kind of hold otherwise.
+	ScheduleStatus string `json:",omitempty"`
+}
+
+// newHVACInfo extracts the HVAC state from a zone status.
+func newHVACInfo(status ZoneStatus) *HVACInfo {
+	info := &HVACInfo{
+		OperatingMode:  status.OperatingMode,
+		FanMode:        status.FanMode,
+		ScheduleStatus: status.ScheduleStatus,
+	}
+	if status.CurrentTemperature != nil {
+		info.CurrentTemperature = NewTemperature(*status.CurrentTemperature)
+	}
+	if status.HeatSetPoint != nil {
+		info.HeatSetPoint = NewTemperature(*status.HeatSetPoint)
+	}
+	if status.CoolSetPoint != nil {
+		info.CoolSetPoint = NewTemperature(*status.CoolSetPoint)
+	}
+	return info
+}
+
+// SetPointCommand builds the body of a CreateRequest which changes the
+// setpoints of an HVAC zone, in Celsius.
+//
+// At least one setpoint must be set. If both are, heat must be below cool.
+func SetPointCommand(heat, cool *float64) (map[string]any, error) {
+	if heat == nil && cool == nil {
+		return nil, errors.New("expected a heat or cool setpoint")
+	}
+	params := map[string]any{}
+	for _, setPoint := range []struct {
+		name  string
+		value *float64
+	}{
+		{"HeatSetPoint", heat},
+		{"CoolSetPoint", cool},
+	} {
+		if setPoint.value == nil {
+			continue
+		}
+		value := roundTenth(*setPoint.value)
+		if math.IsNaN(value) || math.IsInf(value, 0) || value < MinSetPoint || value > MaxSetPoint {
+			return nil, fmt.Errorf("setpoint %.1f°C is outside of the supported range %.0f-%.0f°C",
+				value, MinSetPoint, MaxSetPoint)
+		}
+		params[setPoint.name] = value
+	}
+	if heat != nil && cool != nil && *heat >= *cool {
+		return nil, errors.New("heat setpoint must be below cool setpoint")
+	}
+	return map[string]any{
+		"Command": map[string]any{
+			"CommandType":             "GoToHVACSetPoints",
+			"HVACSetPointsParameters": params,
+		},
+	}, nil
+}
+
+// HVACModeCommand builds the body of a CreateRequest which changes the
+// operating mode and fan mode of an HVAC zone. Empty modes are left alone,
+// but at least one must be set.
+func HVACModeCommand(operatingMode, fanMode string) (map[string]any, error) {
+	if operatingMode == "" && fanMode == "" {
+		return nil, errors.New("expected an operating mode or fan mode")
+	}
+	params := map[string]any{}
+	if operatingMode != "" {
+		if !slices.Contains(HVACOperatingModes, operatingMode) {
+			return nil, fmt.Errorf("unknown operating mode: %s", operatingMode)
+		}
+		params["OperatingMode"] = operatingMode
+	}
+	if fanMode != "" {
+		if !slices.Contains(HVACFanModes, fanMode) {
+			return nil, fmt.Errorf("unknown fan mode: %s", fanMode)
+		}
+		params["FanMode"] = fanMode
+	}
+	return map[string]any{
+		"Command": map[string]any{
+			"CommandType":        "GoToHVACMode",
+			"HVACModeParameters": params,
+		},
+	}, nil
+}
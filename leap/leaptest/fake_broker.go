@@ -0,0 +1,1060 @@
+// Package leaptest provides an in-memory LEAP bridge for testing code that
+// uses the leap package.
+package leaptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+)
+
+// House describes a virtual Lutron system served by a Broker.
+type House struct {
+	Areas           []*Area
+	Devices         []*Device
+	VirtualButtons  []*Button
+	OccupancyGroups []*OccupancyGroup
+}
+
+// An Area is a room or group of rooms. Parent is empty for the root area.
+type Area struct {
+	Href   string
+	Name   string
+	Parent string
+}
+
+// An OccupancyGroup is a group of occupancy sensors covering some areas.
+// Status is "Occupied", "Unoccupied" or "Unknown".
+type OccupancyGroup struct {
+	Href   string
+	Areas  []string
+	Status string
+}
+
+type Device struct {
+	Href               string
+	FullyQualifiedName []string
+	DeviceType         string
+	Area               string
+	Zones              []*Zone
+	Buttons            []*Button
+
+	// Battery is the battery LevelState of battery-powered devices, and
+	// Availability defaults to "Available".
+	Battery      string
+	Availability string
+
+	// SerialNumber, ModelNumber, Firmware and AddressedState are only
+	// reported if they are set.
+	SerialNumber   int
+	ModelNumber    string
+	Firmware       string
+	AddressedState string
+}
+
+type Zone struct {
+	Href        string
+	Name        string
+	ControlType string
+	Level       int
+
+	// Tilt is the tilt of ShadeWithTilt and Tilt zones.
+	Tilt int
+
+	// Color and Vibrancy are the state of tunable zones, and KelvinRange, if
+	// set, is reported in the zone's ColorTuningProperties.
+	Color       *leap.ColorTuningStatus
+	Vibrancy    *int
+	KelvinRange *leap.KelvinRange
+
+	// HVAC is the state of HVAC zones.
+	HVAC *HVAC
+}
+
+// HVAC is the state of a thermostat, with temperatures in Celsius.
+type HVAC struct {
+	Temperature    float64
+	HeatSetPoint   float64
+	CoolSetPoint   float64
+	OperatingMode  string
+	FanMode        string
+	ScheduleStatus string
+}
+
+// A Button is a physical or virtual button. If Preset is non-nil, the
+// button has a single-action programming model which applies the preset when
+// the button is pressed.
+type Button struct {
+	Href         string
+	Name         string
+	ButtonNumber int
+	IsProgrammed bool
+	Preset       *Preset
+}
+
+type Preset struct {
+	Href     string
+	Dimmed   []Assignment
+	Switched []Assignment
+}
+
+type Assignment struct {
+	Href      string
+	Zone      string
+	Level     int
+	FadeTime  string
+	DelayTime string
+}
+
+// Broker is an in-memory leap.BrokerConn which answers requests for a
+// House and applies commands to its own zone state.
+type Broker struct {
+	lock      sync.Mutex
+	resources map[string]any
+	devices   map[string]*Device
+	zones     map[string]*Zone
+	presets   map[string]*Preset
+	buttons   map[string]*Button
+	occupancy map[string]*OccupancyGroup
+	failures  map[string]string
+	drops     map[string]int
+	latency   time.Duration
+	inFlight  int
+	maxFlight int
+	subs      map[string][]string
+	events    []leap.Message
+	requests  []leap.Message
+	pressed   []string
+	hub       *leap.MessageHub
+}
+
+// NewBroker creates a Broker which serves the given house.
+func NewBroker(house *House) *Broker {
+	f := &Broker{
+		resources: map[string]any{},
+		devices:   map[string]*Device{},
+		zones:     map[string]*Zone{},
+		presets:   map[string]*Preset{},
+		buttons:   map[string]*Button{},
+		occupancy: map[string]*OccupancyGroup{},
+		failures:  map[string]string{},
+		drops:     map[string]int{},
+		subs:      map[string][]string{},
+		hub:       leap.NewMessageHub(),
+	}
+	f.resources["/server/1/status/ping"] = map[string]any{
+		"PingResponse": map[string]any{"LEAPVersion": 1.1},
+	}
+
+	var areas, devices, zones, buttons, virtualButtons, models []map[string]any
+	isLeaf := map[string]bool{}
+	for _, a := range house.Areas {
+		isLeaf[a.Href] = true
+	}
+	for _, a := range house.Areas {
+		delete(isLeaf, a.Parent)
+	}
+	for _, a := range house.Areas {
+		area := map[string]any{
+			"href":   a.Href,
+			"Name":   a.Name,
+			"IsLeaf": isLeaf[a.Href],
+		}
+		if a.Parent != "" {
+			area["Parent"] = map[string]any{"href": a.Parent}
+		}
+		areas = append(areas, area)
+		f.resources[a.Href] = map[string]any{"Area": area}
+	}
+	addButton := func(b *Button, parent string) map[string]any {
+		f.buttons[b.Href] = b
+		obj := map[string]any{
+			"href":         b.Href,
+			"Name":         b.Name,
+			"ButtonNumber": b.ButtonNumber,
+		}
+		if parent != "" {
+			obj["Parent"] = map[string]any{"href": parent}
+		}
+		if b.Preset != nil {
+			modelHref := b.Href + "/programmingmodel"
+			obj["ProgrammingModel"] = map[string]any{"href": modelHref}
+			models = append(models, map[string]any{
+				"href":                 modelHref,
+				"ProgrammingModelType": "SingleActionProgrammingModel",
+				"Preset":               map[string]any{"href": b.Preset.Href},
+			})
+			f.addPreset(b.Preset)
+		}
+		return obj
+	}
+
+	for _, d := range house.Devices {
+		f.devices[d.Href] = d
+		device := map[string]any{
+			"href":               d.Href,
+			"Name":               d.FullyQualifiedName[len(d.FullyQualifiedName)-1],
+			"FullyQualifiedName": d.FullyQualifiedName,
+			"DeviceType":         d.DeviceType,
+		}
+		if d.Area != "" {
+			device["AssociatedArea"] = map[string]any{"href": d.Area}
+		}
+		if d.SerialNumber != 0 {
+			device["SerialNumber"] = d.SerialNumber
+		}
+		if d.ModelNumber != "" {
+			device["ModelNumber"] = d.ModelNumber
+		}
+		if d.Firmware != "" {
+			device["FirmwareImage"] = map[string]any{
+				"Firmware": map[string]any{"DisplayName": d.Firmware},
+			}
+		}
+		if d.AddressedState != "" {
+			device["AddressedState"] = d.AddressedState
+		}
+		var localZones []map[string]any
+		for _, z := range d.Zones {
+			f.zones[z.Href] = z
+			localZones = append(localZones, map[string]any{"href": z.Href})
+			zone := map[string]any{
+				"href":        z.Href,
+				"Name":        z.Name,
+				"ControlType": z.ControlType,
+				"Device":      map[string]any{"href": d.Href},
+			}
+			if z.KelvinRange != nil {
+				zone["ColorTuningProperties"] = map[string]any{"WhiteTuningLevelRange": z.KelvinRange}
+			}
+			zones = append(zones, zone)
+			f.resources[z.Href] = map[string]any{"Zone": zone}
+		}
+		if len(localZones) > 0 {
+			device["LocalZones"] = localZones
+		}
+		if len(d.Buttons) > 0 {
+			groupHref := d.Href + "/buttongroup"
+			device["ButtonGroups"] = []map[string]any{{"href": groupHref}}
+			for _, b := range d.Buttons {
+				buttons = append(buttons, addButton(b, groupHref))
+			}
+		}
+		devices = append(devices, device)
+		f.resources[d.Href] = map[string]any{"Device": device}
+	}
+	for _, b := range house.VirtualButtons {
+		obj := addButton(b, "")
+		obj["IsProgrammed"] = b.IsProgrammed
+		virtualButtons = append(virtualButtons, obj)
+	}
+
+	var buttonStatuses []map[string]any
+	for _, b := range buttons {
+		buttonStatuses = append(buttonStatuses, fakeButtonStatus(b["href"].(string), "Release"))
+	}
+	f.resources["/button/status"] = map[string]any{"ButtonStatuses": buttonStatuses}
+
+	var occupancyGroups []map[string]any
+	for _, g := range house.OccupancyGroups {
+		f.occupancy[g.Href] = g
+		var associated []map[string]any
+		for _, area := range g.Areas {
+			associated = append(associated, map[string]any{"Area": map[string]any{"href": area}})
+		}
+		group := map[string]any{"href": g.Href, "AssociatedAreas": associated}
+		occupancyGroups = append(occupancyGroups, group)
+		f.resources[g.Href] = map[string]any{"OccupancyGroup": group}
+	}
+	if len(occupancyGroups) > 0 {
+		f.resources["/occupancygroup"] = map[string]any{"OccupancyGroups": occupancyGroups}
+	}
+	if len(areas) > 0 {
+		f.resources["/area"] = map[string]any{"Areas": areas}
+	}
+	f.resources["/device"] = map[string]any{"Devices": devices}
+	f.resources["/zone"] = map[string]any{"Zones": zones}
+	f.resources["/button"] = map[string]any{"Buttons": buttons}
+	f.resources["/virtualbutton"] = map[string]any{"VirtualButtons": virtualButtons}
+	f.resources["/programmingmodel"] = map[string]any{"ProgrammingModels": models}
+	return f
+}
+
+func (f *Broker) addPreset(p *Preset) {
+	if _, ok := f.presets[p.Href]; ok {
+		return
+	}
+	f.presets[p.Href] = p
+	var dimmed, switched []map[string]any
+	for _, a := range p.Dimmed {
+		dimmed = append(dimmed, map[string]any{"href": a.Href})
+		f.resources[a.Href] = map[string]any{
+			"DimmedLevelAssignment": map[string]any{
+				"href":               a.Href,
+				"Parent":             map[string]any{"href": p.Href},
+				"AssignableResource": map[string]any{"href": a.Zone},
+				"FadeTime":           a.FadeTime,
+				"DelayTime":          a.DelayTime,
+				"Level":              a.Level,
+			},
+		}
+	}
+	for _, a := range p.Switched {
+		switched = append(switched, map[string]any{"href": a.Href})
+		f.resources[a.Href] = map[string]any{
+			"SwitchedLevelAssignment": map[string]any{
+				"href":               a.Href,
+				"Parent":             map[string]any{"href": p.Href},
+				"AssignableResource": map[string]any{"href": a.Zone},
+				"DelayTime":          a.DelayTime,
+				"SwitchedLevel":      switchedLevelName(a.Level),
+			},
+		}
+	}
+	f.resources[p.Href] = map[string]any{
+		"Preset": map[string]any{
+			"href":                     p.Href,
+			"DimmedLevelAssignments":   dimmed,
+			"SwitchedLevelAssignments": switched,
+		},
+	}
+}
+
+// FailURL causes all future requests to url to fail with the given LEAP
+// status, e.g. "404 NotFound". An empty status removes the failure.
+func (f *Broker) FailURL(url, status string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if status == "" {
+		delete(f.failures, url)
+	} else {
+		f.failures[url] = status
+	}
+}
+
+// SetZoneLevel changes a zone's level as if it were changed at a wall
+// control, notifying subscribers.
+func (f *Broker) SetZoneLevel(href string, level int) {
+	f.lock.Lock()
+	f.setZoneLevel(f.zones[href], level)
+	events := f.events
+	f.events = nil
+	f.lock.Unlock()
+	for _, event := range events {
+		f.hub.Publish(event)
+	}
+}
+
+// SetDeviceStatus changes the battery level and availability of a device,
+// notifying subscribers.
+func (f *Broker) SetDeviceStatus(href, battery, availability string) {
+	f.lock.Lock()
+	device := f.devices[href]
+	device.Battery = battery
+	device.Availability = availability
+	body, _ := json.Marshal(map[string]any{"DeviceStatus": fakeDeviceStatus(device)})
+	for _, url := range []string{"/device/status", href + "/status"} {
+		for _, tag := range f.subs[url] {
+			f.events = append(f.events, leap.Message{
+				CommuniqueType: "ReadResponse",
+				Header: leap.Header{
+					ClientTag:       tag,
+					Url:             href + "/status",
+					StatusCode:      "200 OK",
+					MessageBodyType: "OneDeviceStatus",
+				},
+				Body: body,
+			})
+		}
+	}
+	events := f.events
+	f.events = nil
+	f.lock.Unlock()
+	for _, event := range events {
+		f.hub.Publish(event)
+	}
+}
+
+// SetOccupancy changes the status of an occupancy group, notifying
+// subscribers.
+func (f *Broker) SetOccupancy(href, status string) {
+	f.lock.Lock()
+	f.occupancy[href].Status = status
+	f.queueOccupancyStatus(f.occupancy[href])
+	events := f.events
+	f.events = nil
+	f.lock.Unlock()
+	for _, event := range events {
+		f.hub.Publish(event)
+	}
+}
+
+// DropResponses causes the next n requests to url to go unanswered.
+func (f *Broker) DropResponses(url string, n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.drops[url] = n
+}
+
+// SetLatency delays every response by d.
+func (f *Broker) SetLatency(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.latency = d
+}
+
+// MaxInFlight returns the largest number of requests that were being handled
+// at once.
+func (f *Broker) MaxInFlight() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.maxFlight
+}
+
+// ZoneLevel returns the current level of a zone.
+func (f *Broker) ZoneLevel(href string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.zones[href].Level
+}
+
+// ZoneTilt returns the current tilt of a zone.
+func (f *Broker) ZoneTilt(href string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.zones[href].Tilt
+}
+
+// ZoneHVAC returns the current state of an HVAC zone.
+func (f *Broker) ZoneHVAC(href string) HVAC {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return *f.zones[href].HVAC
+}
+
+// Requests returns every message sent to the broker so far.
+func (f *Broker) Requests() []leap.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]leap.Message{}, f.requests...)
+}
+
+// RequestCount counts the requests sent to a given URL.
+func (f *Broker) RequestCount(url string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var count int
+	for _, msg := range f.requests {
+		if msg.Header.Url == url {
+			count++
+		}
+	}
+	return count
+}
+
+// Pressed returns the hrefs of buttons that have been pressed, in order.
+func (f *Broker) Pressed() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.pressed...)
+}
+
+func (f *Broker) Send(msg leap.Message) error {
+	select {
+	case <-f.hub.Done():
+		return leap.ErrConnClosed
+	default:
+	}
+	f.lock.Lock()
+	f.requests = append(f.requests, msg)
+	f.lock.Unlock()
+
+	go f.handle(msg)
+	return nil
+}
+
+func (f *Broker) Subscribe(ctx context.Context, ch chan<- leap.Message, fs ...func() error) error {
+	return f.hub.Subscribe(ctx, ch, fs...)
+}
+
+func (f *Broker) Call(ctx context.Context, msg leap.Message, fn func(leap.Message) (bool, error)) (leap.Message, error) {
+	return f.hub.Call(ctx, f.Send, msg, fn)
+}
+
+func (f *Broker) Close() error {
+	if !f.hub.Shutdown(nil) {
+		return leap.ErrConnClosed
+	}
+	return nil
+}
+
+func (f *Broker) Error() error {
+	return f.hub.Error()
+}
+
+func (f *Broker) handle(msg leap.Message) {
+	f.lock.Lock()
+	failure := f.failures[msg.Header.Url]
+	drop := f.drops[msg.Header.Url] > 0
+	if drop {
+		f.drops[msg.Header.Url]--
+	}
+	latency := f.latency
+	f.inFlight++
+	f.maxFlight = max(f.maxFlight, f.inFlight)
+	f.lock.Unlock()
+
+	defer func() {
+		f.lock.Lock()
+		f.inFlight--
+		f.lock.Unlock()
+	}()
+	time.Sleep(latency)
+	if drop {
+		return
+	}
+
+	var response leap.Message
+	switch {
+	case failure != "":
+		response = fakeError(msg, failure, "injected failure")
+	case msg.CommuniqueType == "ReadRequest":
+		response = f.handleRead(msg)
+	case msg.CommuniqueType == "CreateRequest":
+		response = f.handleCreate(msg)
+	case msg.CommuniqueType == "UpdateRequest":
+		response = f.handleUpdate(msg)
+	case msg.CommuniqueType == "DeleteRequest":
+		response = f.handleDelete(msg)
+	case msg.CommuniqueType == "SubscribeRequest":
+		response = f.handleSubscribe(msg)
+	default:
+		response = fakeError(msg, "400 BadRequest", "unsupported communique type: "+msg.CommuniqueType)
+	}
+
+	f.lock.Lock()
+	events := f.events
+	f.events = nil
+	f.lock.Unlock()
+
+	f.hub.Publish(response)
+	for _, event := range events {
+		f.hub.Publish(event)
+	}
+}
+
+func (f *Broker) handleRead(msg leap.Message) leap.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	url := msg.Header.Url
+	if url == "/zone/status" {
+		var statuses []map[string]any
+		for _, href := range sortedKeys(f.zones) {
+			statuses = append(statuses, f.zoneStatus(f.zones[href]))
+		}
+		return fakeResponse(msg, "ReadResponse", "200 OK", map[string]any{"ZoneStatuses": statuses})
+	} else if url == "/device/status" {
+		var statuses []map[string]any
+		for _, href := range sortedKeys(f.devices) {
+			statuses = append(statuses, fakeDeviceStatus(f.devices[href]))
+		}
+		return fakeResponse(msg, "ReadResponse", "200 OK", map[string]any{"DeviceStatuses": statuses})
+	} else if deviceHref, ok := strings.CutSuffix(url, "/status"); ok && f.devices[deviceHref] != nil {
+		status := fakeDeviceStatus(f.devices[deviceHref])
+		return fakeResponse(msg, "ReadResponse", "200 OK", map[string]any{"DeviceStatus": status})
+	} else if url == "/occupancygroup/status" && len(f.occupancy) > 0 {
+		var statuses []map[string]any
+		for _, href := range sortedKeys(f.occupancy) {
+			statuses = append(statuses, fakeOccupancyStatus(f.occupancy[href]))
+		}
+		return fakeResponse(msg, "ReadResponse", "200 OK", map[string]any{"OccupancyGroupStatuses": statuses})
+	} else if groupHref, ok := strings.CutSuffix(url, "/status"); ok && f.occupancy[groupHref] != nil {
+		status := fakeOccupancyStatus(f.occupancy[groupHref])
+		return fakeResponse(msg, "ReadResponse", "200 OK", map[string]any{"OccupancyGroupStatus": status})
+	} else if zoneHref, ok := strings.CutSuffix(url, "/status"); ok && f.zones[zoneHref] != nil {
+		status := f.zoneStatus(f.zones[zoneHref])
+		return fakeResponse(msg, "ReadResponse", "200 OK", map[string]any{"ZoneStatus": status})
+	} else if obj, ok := f.resources[url]; ok {
+		return fakeResponse(msg, "ReadResponse", "200 OK", obj)
+	}
+	return fakeError(msg, "404 NotFound", "The specified resource does not exist")
+}
+
+func (f *Broker) handleCreate(msg leap.Message) leap.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var body struct {
+		Command struct {
+			CommandType           string
+			DimmedLevelParameters *struct {
+				Level int
+			}
+			SwitchedLevelParameters *struct {
+				SwitchedLevel string
+			}
+			Parameter *struct {
+				Type  string
+				Value int
+			}
+			ShadeLevelParameters          *levelParameters
+			WhiteTuningLevelParameters    *tuningParameters
+			SpectrumTuningLevelParameters *tuningParameters
+			FanSpeedParameters            *struct {
+				FanSpeed string
+			}
+			CCOLevelParameters *struct {
+				CCOLevel string
+			}
+			TiltParameters *struct {
+				Tilt int
+			}
+			HVACSetPointsParameters *struct {
+				HeatSetPoint *float64
+				CoolSetPoint *float64
+			}
+			HVACModeParameters *struct {
+				OperatingMode string
+				FanMode       string
+			}
+		}
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return fakeError(msg, "400 BadRequest", err.Error())
+	}
+	cmd := body.Command
+	if err := checkCommandTimes(msg.Body); err != nil {
+		return fakeError(msg, "400 BadRequest", err.Error())
+	}
+
+	target, ok := strings.CutSuffix(msg.Header.Url, "/commandprocessor")
+	if !ok {
+		return fakeError(msg, "400 BadRequest", "unsupported create URL: "+msg.Header.Url)
+	}
+
+	if zone, ok := f.zones[target]; ok {
+		switch {
+		case cmd.CommandType == "GoToDimmedLevel" && cmd.DimmedLevelParameters != nil:
+			f.setZoneLevel(zone, cmd.DimmedLevelParameters.Level)
+		case cmd.CommandType == "GoToSwitchedLevel" && cmd.SwitchedLevelParameters != nil:
+			f.setZoneLevel(zone, switchedLevelValue(cmd.SwitchedLevelParameters.SwitchedLevel))
+		case cmd.CommandType == "GoToLevel" && cmd.Parameter != nil:
+			f.setZoneLevel(zone, cmd.Parameter.Value)
+		case cmd.CommandType == "GoToShadeLevel" && cmd.ShadeLevelParameters != nil:
+			f.setZoneLevel(zone, cmd.ShadeLevelParameters.Level)
+		case cmd.CommandType == "GoToWhiteTuningLevel" && cmd.WhiteTuningLevelParameters != nil:
+			if err := f.tuneZone(zone, cmd.WhiteTuningLevelParameters); err != nil {
+				return fakeError(msg, "400 BadRequest", err.Error())
+			}
+		case cmd.CommandType == "GoToSpectrumTuningLevel" && cmd.SpectrumTuningLevelParameters != nil:
+			if err := f.tuneZone(zone, cmd.SpectrumTuningLevelParameters); err != nil {
+				return fakeError(msg, "400 BadRequest", err.Error())
+			}
+		case cmd.CommandType == "GoToFanSpeed" && cmd.FanSpeedParameters != nil:
+			level, ok := leap.FanSpeedLevel(cmd.FanSpeedParameters.FanSpeed)
+			if !ok {
+				return fakeError(msg, "400 BadRequest", "unknown fan speed: "+cmd.FanSpeedParameters.FanSpeed)
+			}
+			f.setZoneLevel(zone, level)
+		case cmd.CommandType == "GoToCCOLevel" && cmd.CCOLevelParameters != nil:
+			if cmd.CCOLevelParameters.CCOLevel == "Closed" {
+				f.setZoneLevel(zone, 100)
+			} else {
+				f.setZoneLevel(zone, 0)
+			}
+		case cmd.CommandType == "GoToTilt" && cmd.TiltParameters != nil:
+			if zone.ControlType != "ShadeWithTilt" && zone.ControlType != "Tilt" {
+				return fakeError(msg, "400 BadRequest", "zone does not support tilt")
+			}
+			zone.Tilt = cmd.TiltParameters.Tilt
+			f.queueZoneStatus(zone)
+		case cmd.CommandType == "GoToHVACSetPoints" && cmd.HVACSetPointsParameters != nil:
+			if zone.HVAC == nil {
+				return fakeError(msg, "400 BadRequest", "zone is not an HVAC zone")
+			}
+			params := cmd.HVACSetPointsParameters
+			if params.HeatSetPoint != nil {
+				zone.HVAC.HeatSetPoint = *params.HeatSetPoint
+			}
+			if params.CoolSetPoint != nil {
+				zone.HVAC.CoolSetPoint = *params.CoolSetPoint
+			}
+			f.queueZoneStatus(zone)
+		case cmd.CommandType == "GoToHVACMode" && cmd.HVACModeParameters != nil:
+			if zone.HVAC == nil {
+				return fakeError(msg, "400 BadRequest", "zone is not an HVAC zone")
+			}
+			if mode := cmd.HVACModeParameters.OperatingMode; mode != "" {
+				zone.HVAC.OperatingMode = mode
+			}
+			if mode := cmd.HVACModeParameters.FanMode; mode != "" {
+				zone.HVAC.FanMode = mode
+			}
+			f.queueZoneStatus(zone)
+		case cmd.CommandType == "Raise":
+			f.setZoneLevel(zone, 100)
+		case cmd.CommandType == "Lower":
+			f.setZoneLevel(zone, 0)
+		case cmd.CommandType == "Stop":
+		default:
+			return fakeError(msg, "400 BadRequest", "unsupported zone command: "+cmd.CommandType)
+		}
+		return fakeResponse(msg, "CreateResponse", "201 Created", map[string]any{"Command": cmd})
+	} else if button, ok := f.buttons[target]; ok {
+		if cmd.CommandType != "PressAndRelease" {
+			return fakeError(msg, "400 BadRequest", "unsupported button command: "+cmd.CommandType)
+		}
+		f.pressed = append(f.pressed, button.Href)
+		f.queueButtonEvent(button.Href, "Press")
+		f.queueButtonEvent(button.Href, "Release")
+		if button.Preset != nil {
+			for _, a := range button.Preset.Dimmed {
+				if zone, ok := f.zones[a.Zone]; ok {
+					f.setZoneLevel(zone, a.Level)
+				}
+			}
+			for _, a := range button.Preset.Switched {
+				if zone, ok := f.zones[a.Zone]; ok {
+					f.setZoneLevel(zone, a.Level)
+				}
+			}
+		}
+		return fakeResponse(msg, "CreateResponse", "201 Created", map[string]any{"Command": cmd})
+	}
+	return fakeError(msg, "404 NotFound", "The specified resource does not exist")
+}
+
+func (f *Broker) handleUpdate(msg leap.Message) leap.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	obj, ok := f.resources[msg.Header.Url].(map[string]any)
+	if !ok {
+		return fakeError(msg, "404 NotFound", "The specified resource does not exist")
+	}
+	var body map[string]map[string]any
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return fakeError(msg, "400 BadRequest", err.Error())
+	}
+	for key, fields := range body {
+		inner, ok := obj[key].(map[string]any)
+		if !ok {
+			return fakeError(msg, "400 BadRequest", "unexpected update key: "+key)
+		}
+		for k, v := range fields {
+			inner[k] = v
+		}
+	}
+	if zone, ok := f.zones[msg.Header.Url]; ok {
+		if name, ok := obj["Zone"].(map[string]any)["Name"].(string); ok {
+			zone.Name = name
+		}
+	}
+	return fakeResponse(msg, "UpdateResponse", "200 OK", obj)
+}
+
+func (f *Broker) handleDelete(msg leap.Message) leap.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.resources[msg.Header.Url]; !ok {
+		return fakeError(msg, "404 NotFound", "The specified resource does not exist")
+	}
+	delete(f.resources, msg.Header.Url)
+	res := fakeResponse(msg, "DeleteResponse", "204 NoContent", nil)
+	res.Body = nil
+	return res
+}
+
+func (f *Broker) handleSubscribe(msg leap.Message) leap.Message {
+	res := f.handleRead(msg)
+	res.CommuniqueType = "SubscribeResponse"
+	if strings.HasPrefix(res.Header.StatusCode, "200") {
+		f.lock.Lock()
+		url := msg.Header.Url
+		f.subs[url] = append(f.subs[url], msg.Header.ClientTag)
+		f.lock.Unlock()
+	}
+	return res
+}
+
+// setZoneLevel updates a zone and queues status events for subscribers.
+//
+// The caller must hold f.lock.
+func (f *Broker) setZoneLevel(z *Zone, level int) {
+	z.Level = level
+	f.queueZoneStatus(z)
+}
+
+// queueZoneStatus queues a zone's status for subscribers.
+//
+// The caller must hold f.lock.
+func (f *Broker) queueZoneStatus(z *Zone) {
+	body, _ := json.Marshal(map[string]any{"ZoneStatus": f.zoneStatus(z)})
+	for _, url := range []string{"/zone/status", z.Href + "/status"} {
+		for _, tag := range f.subs[url] {
+			f.events = append(f.events, leap.Message{
+				CommuniqueType: "ReadResponse",
+				Header: leap.Header{
+					ClientTag:       tag,
+					Url:             z.Href + "/status",
+					StatusCode:      "200 OK",
+					MessageBodyType: "OneZoneStatus",
+				},
+				Body: body,
+			})
+		}
+	}
+}
+
+// queueButtonEvent queues a button event for subscribers.
+//
+// The caller must hold f.lock.
+func (f *Broker) queueButtonEvent(href, eventType string) {
+	body, _ := json.Marshal(map[string]any{"ButtonStatus": fakeButtonStatus(href, eventType)})
+	for _, url := range []string{"/button/status", href + "/status/event"} {
+		for _, tag := range f.subs[url] {
+			f.events = append(f.events, leap.Message{
+				CommuniqueType: "ReadResponse",
+				Header: leap.Header{
+					ClientTag:       tag,
+					Url:             href + "/status/event",
+					StatusCode:      "200 OK",
+					MessageBodyType: "OneButtonStatusEvent",
+				},
+				Body: body,
+			})
+		}
+	}
+}
+
+// queueOccupancyStatus queues an occupancy group's status for subscribers.
+//
+// The caller must hold f.lock.
+func (f *Broker) queueOccupancyStatus(g *OccupancyGroup) {
+	body, _ := json.Marshal(map[string]any{
+		"OccupancyGroupStatuses": []map[string]any{fakeOccupancyStatus(g)},
+	})
+	for _, url := range []string{"/occupancygroup/status", g.Href + "/status"} {
+		for _, tag := range f.subs[url] {
+			f.events = append(f.events, leap.Message{
+				CommuniqueType: "ReadResponse",
+				Header: leap.Header{
+					ClientTag:       tag,
+					Url:             "/occupancygroup/status",
+					StatusCode:      "200 OK",
+					MessageBodyType: "MultipleOccupancyGroupStatus",
+				},
+				Body: body,
+			})
+		}
+	}
+}
+
+func (f *Broker) zoneStatus(z *Zone) map[string]any {
+	status := map[string]any{
+		"href":           z.Href + "/status",
+		"Zone":           map[string]any{"href": z.Href},
+		"StatusAccuracy": "Good",
+	}
+	switch z.ControlType {
+	case "Switched":
+		status["SwitchedLevel"] = switchedLevelName(z.Level)
+	case "WhiteTune", "SpectrumTune", "ColorTune":
+		if z.Color != nil {
+			status["ColorTuningStatus"] = z.Color
+		}
+		if z.Vibrancy != nil {
+			status["Vibrancy"] = *z.Vibrancy
+		}
+	case "ShadeWithTilt":
+		status["Tilt"] = z.Tilt
+	case "Tilt":
+		status["Tilt"] = z.Tilt
+		return status
+	case "FanSpeed":
+		// Like real bridges, fans report a speed instead of a level.
+		status["FanSpeed"] = leap.FanSpeedForLevel(z.Level)
+		return status
+	case "HVAC":
+		if z.HVAC != nil {
+			status["CurrentTemperature"] = z.HVAC.Temperature
+			status["HeatSetPoint"] = z.HVAC.HeatSetPoint
+			status["CoolSetPoint"] = z.HVAC.CoolSetPoint
+			status["OperatingMode"] = z.HVAC.OperatingMode
+			status["FanMode"] = z.HVAC.FanMode
+			status["ScheduleStatus"] = z.HVAC.ScheduleStatus
+		}
+		return status
+	}
+	status["Level"] = z.Level
+	return status
+}
+
+func fakeResponse(req leap.Message, communiqueType, status string, body any) leap.Message {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		panic(fmt.Sprintf("encode fake response: %s", err))
+	}
+	return leap.Message{
+		CommuniqueType: communiqueType,
+		Header: leap.Header{
+			ClientTag:  req.Header.ClientTag,
+			Url:        req.Header.Url,
+			StatusCode: status,
+		},
+		Body: encoded,
+	}
+}
+
+func fakeError(req leap.Message, status, message string) leap.Message {
+	communiqueType := strings.Replace(req.CommuniqueType, "Request", "Response", 1)
+	res := fakeResponse(req, communiqueType, status, map[string]any{"Message": message})
+	res.Header.MessageBodyType = "ExceptionDetail"
+	return res
+}
+
+func fakeButtonStatus(href, eventType string) map[string]any {
+	return map[string]any{
+		"href":        href + "/status/event",
+		"Button":      map[string]any{"href": href},
+		"ButtonEvent": map[string]any{"EventType": eventType},
+	}
+}
+
+func fakeDeviceStatus(d *Device) map[string]any {
+	status := map[string]any{
+		"href":         d.Href + "/status",
+		"Device":       map[string]any{"href": d.Href},
+		"Availability": "Available",
+	}
+	if d.Availability != "" {
+		status["Availability"] = d.Availability
+	}
+	if d.Battery != "" {
+		status["BatteryStatus"] = map[string]any{"LevelState": d.Battery}
+	}
+	return status
+}
+
+func fakeOccupancyStatus(g *OccupancyGroup) map[string]any {
+	return map[string]any{
+		"href":            g.Href + "/status",
+		"OccupancyGroup":  map[string]any{"href": g.Href},
+		"OccupancyStatus": g.Status,
+	}
+}
+
+func switchedLevelName(level int) string {
+	if level == 0 {
+		return "Off"
+	}
+	return "On"
+}
+
+// checkCommandTimes validates the FadeTime and DelayTime of a command's
+// parameters, if present.
+func checkCommandTimes(body json.RawMessage) error {
+	var command struct {
+		Command map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(body, &command); err != nil {
+		return err
+	}
+	for _, value := range command.Command {
+		var params struct {
+			FadeTime  *string
+			DelayTime *string
+		}
+		if json.Unmarshal(value, &params) != nil {
+			// Not a parameters object.
+			continue
+		}
+		for _, t := range []*string{params.FadeTime, params.DelayTime} {
+			if t == nil {
+				continue
+			}
+			if _, err := leap.ParseDuration(*t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type levelParameters struct {
+	Level int
+}
+
+// tuningParameters are the parameters of white and spectrum tuning commands.
+// Each field is optional.
+type tuningParameters struct {
+	Level             *int
+	WhiteTuningLevel  *leap.WhiteTuningLevel
+	ColorTuningStatus *leap.ColorTuningStatus
+	Vibrancy          *int
+}
+
+// tuneZone applies a tuning command to a zone.
+//
+// The caller must hold f.lock.
+func (f *Broker) tuneZone(z *Zone, params *tuningParameters) error {
+	color := params.ColorTuningStatus
+	if params.WhiteTuningLevel != nil {
+		color = &leap.ColorTuningStatus{WhiteTuningLevel: params.WhiteTuningLevel}
+	}
+	if color != nil && color.WhiteTuningLevel != nil && z.KelvinRange != nil {
+		kelvin := color.WhiteTuningLevel.Kelvin
+		if kelvin < z.KelvinRange.Min || kelvin > z.KelvinRange.Max {
+			return fmt.Errorf("color temperature %d is out of range", kelvin)
+		}
+	}
+	if color != nil {
+		z.Color = color
+	}
+	if params.Vibrancy != nil {
+		z.Vibrancy = params.Vibrancy
+	}
+	if params.Level != nil {
+		f.setZoneLevel(z, *params.Level)
+	} else {
+		f.queueZoneStatus(z)
+	}
+	return nil
+}
+
+func switchedLevelValue(name string) int {
+	if name == "On" {
+		return 100
+	}
+	return 0
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WaitFor polls f until it returns true, failing the test after a timeout.
+func WaitFor(t testing.TB, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,404 @@
+package leaptest
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+)
+
+// LocalServer stands in for a bridge on the local network, serving a Broker
+// as LEAP over TLS and accepting pairing requests.
+type LocalServer struct {
+	Broker *Broker
+
+	// Addr is the address of the LEAP listener.
+	Addr string
+
+	// PairingAddr is the address of the pairing listener.
+	PairingAddr string
+
+	// PairingCredentials are accepted by the pairing listener, like the
+	// certificate that Lutron's apps use to pair.
+	PairingCredentials *leap.LocalCredentials
+
+	bridgeCA        *certAuthority
+	listener        net.Listener
+	pairingListener net.Listener
+
+	pressOnce sync.Once
+	pressed   chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+
+	lock  sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewLocalServer starts listening on random local ports.
+func NewLocalServer(broker *Broker) (*LocalServer, error) {
+	bridgeCA, err := newCertAuthority("Bridge CA")
+	if err != nil {
+		return nil, err
+	}
+	pairingCA, err := newCertAuthority("Pairing CA")
+	if err != nil {
+		return nil, err
+	}
+	serverCert, serverKey, err := bridgeCA.issueKeyPair("Bridge", true)
+	if err != nil {
+		return nil, err
+	}
+	pairingCert, pairingKey, err := pairingCA.issueKeyPair("Pairing Client", false)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(serverCert), []byte(serverKey))
+	if err != nil {
+		return nil, err
+	}
+
+	listen := func(clientCA *certAuthority) (net.Listener, error) {
+		return tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCA.pool(),
+		})
+	}
+	listener, err := listen(bridgeCA)
+	if err != nil {
+		return nil, err
+	}
+	pairingListener, err := listen(pairingCA)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	l := &LocalServer{
+		Broker:      broker,
+		Addr:        listener.Addr().String(),
+		PairingAddr: pairingListener.Addr().String(),
+		PairingCredentials: &leap.LocalCredentials{
+			Certificate:     pairingCert,
+			PrivateKey:      pairingKey,
+			RootCertificate: bridgeCA.pem,
+		},
+		bridgeCA:        bridgeCA,
+		listener:        listener,
+		pairingListener: pairingListener,
+		pressed:         make(chan struct{}),
+		done:            make(chan struct{}),
+		conns:           map[net.Conn]struct{}{},
+	}
+	l.wg.Add(2)
+	go l.acceptLoop(listener, l.serveLEAP)
+	go l.acceptLoop(pairingListener, l.servePairing)
+	return l, nil
+}
+
+// Credentials issues client credentials which the LEAP listener accepts, as
+// if the client had already paired.
+func (l *LocalServer) Credentials() (*leap.LocalCredentials, error) {
+	cert, key, err := l.bridgeCA.issueKeyPair("Client", false)
+	if err != nil {
+		return nil, err
+	}
+	return &leap.LocalCredentials{
+		Certificate:     cert,
+		PrivateKey:      key,
+		RootCertificate: l.bridgeCA.pem,
+	}, nil
+}
+
+// PressButton simulates pressing the button on the back of the bridge,
+// allowing pending and future pairing requests to proceed.
+func (l *LocalServer) PressButton() {
+	l.pressOnce.Do(func() {
+		close(l.pressed)
+	})
+}
+
+// CloseConnections disconnects all clients, as if the bridge restarted.
+func (l *LocalServer) CloseConnections() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the listeners and disconnects all clients.
+func (l *LocalServer) Close() error {
+	close(l.done)
+	err := errors.Join(l.listener.Close(), l.pairingListener.Close())
+	l.CloseConnections()
+	l.wg.Wait()
+	return err
+}
+
+func (l *LocalServer) acceptLoop(listener net.Listener, handler func(net.Conn)) {
+	defer l.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		l.lock.Lock()
+		select {
+		case <-l.done:
+			// Close() already disconnected everybody else.
+			l.lock.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		l.conns[conn] = struct{}{}
+		l.lock.Unlock()
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			defer func() {
+				l.lock.Lock()
+				delete(l.conns, conn)
+				l.lock.Unlock()
+				conn.Close()
+			}()
+			if err := conn.(*tls.Conn).Handshake(); err != nil {
+				return
+			}
+			handler(conn)
+		}()
+	}
+}
+
+func (l *LocalServer) serveLEAP(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages := make(chan leap.Message, 16)
+	subscribed := make(chan struct{})
+	go func() {
+		defer conn.Close()
+		l.Broker.Subscribe(ctx, messages, func() error {
+			close(subscribed)
+			return nil
+		})
+	}()
+	select {
+	case <-subscribed:
+	case <-ctx.Done():
+		return
+	}
+	go func() {
+		for {
+			select {
+			case msg := <-messages:
+				if err := writeLine(conn, msg); err != nil {
+					conn.Close()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var msg leap.Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return
+		}
+		if err := l.Broker.Send(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (l *LocalServer) servePairing(conn net.Conn) {
+	select {
+	case <-l.pressed:
+	case <-l.done:
+		return
+	}
+	status := map[string]any{
+		"Header": map[string]any{
+			"StatusCode":  "200 OK",
+			"ContentType": "status;plurality=single",
+		},
+		"Body": map[string]any{
+			"Status": map[string]any{"Permissions": []string{"Public", "PhysicalAccess"}},
+		},
+	}
+	if err := writeLine(conn, status); err != nil {
+		return
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var request struct {
+		Header struct {
+			ClientTag string
+		}
+		Body struct {
+			CommandType string
+			Parameters  struct {
+				CSR string
+			}
+		}
+	}
+	if err := json.Unmarshal(line, &request); err != nil {
+		return
+	}
+	cert, err := l.signCSR(request.Body.Parameters.CSR)
+	if err != nil {
+		writeLine(conn, map[string]any{
+			"Header": map[string]any{
+				"StatusCode": "400 BadRequest",
+				"ClientTag":  request.Header.ClientTag,
+			},
+			"Body": map[string]any{"Message": err.Error()},
+		})
+		return
+	}
+	writeLine(conn, map[string]any{
+		"Header": map[string]any{
+			"StatusCode":  "200 OK",
+			"ContentType": "signing-result;plurality=single",
+			"ClientTag":   request.Header.ClientTag,
+		},
+		"Body": map[string]any{
+			"SigningResult": map[string]any{
+				"Certificate":     cert,
+				"RootCertificate": l.bridgeCA.pem,
+			},
+		},
+	})
+}
+
+func (l *LocalServer) signCSR(csrPEM string) (string, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil {
+		return "", errors.New("invalid CSR")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return "", err
+	}
+	return l.bridgeCA.issue(csr.Subject.CommonName, csr.PublicKey, false)
+}
+
+func writeLine(conn net.Conn, obj any) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\r', '\n'))
+	return err
+}
+
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func newCertAuthority(name string) (*certAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &certAuthority{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}, nil
+}
+
+func (c *certAuthority) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+// issue creates a PEM-encoded certificate for a public key.
+func (c *certAuthority) issue(name string, pub any, server bool) (string, error) {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return "", err
+	}
+	usage := x509.ExtKeyUsageClientAuth
+	if server {
+		usage = x509.ExtKeyUsageServerAuth
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, pub, c.key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// issueKeyPair creates a new key and a PEM-encoded certificate for it.
+func (c *certAuthority) issueKeyPair(name string, server bool) (cert, key string, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	cert, err = c.issue(name, &priv.PublicKey, server)
+	if err != nil {
+		return "", "", err
+	}
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return "", "", err
+	}
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+}
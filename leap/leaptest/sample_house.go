@@ -0,0 +1,112 @@
+package leaptest
+
+// SampleHouse returns a small two-floor house with a dimmer, a switch, a
+// shade, a two-button Pico remote, two scenes, one of which is unprogrammed,
+// and an occupancy sensor in the kitchen.
+func SampleHouse() *House {
+	return &House{
+		Areas: []*Area{
+			{Href: "/area/1", Name: "Home"},
+			{Href: "/area/5", Name: "Downstairs", Parent: "/area/1"},
+			{Href: "/area/2", Name: "Kitchen", Parent: "/area/5"},
+			{Href: "/area/3", Name: "Living Room", Parent: "/area/5"},
+			{Href: "/area/6", Name: "Upstairs", Parent: "/area/1"},
+			{Href: "/area/4", Name: "Bedroom", Parent: "/area/6"},
+		},
+		Devices: []*Device{
+			{
+				Href:               "/device/1",
+				FullyQualifiedName: []string{"Smart Bridge"},
+				DeviceType:         "SmartBridge",
+			},
+			{
+				Href:               "/device/2",
+				FullyQualifiedName: []string{"Kitchen", "Island"},
+				DeviceType:         "WallDimmer",
+				Area:               "/area/2",
+				SerialNumber:       12345678,
+				ModelNumber:        "PD-6WCL-XX",
+				Firmware:           "001.005.000r000",
+				AddressedState:     "Addressed",
+				Zones: []*Zone{
+					{Href: "/zone/1", Name: "Island", ControlType: "Dimmed", Level: 50},
+				},
+			},
+			{
+				Href:               "/device/3",
+				FullyQualifiedName: []string{"Living Room", "Lamp"},
+				DeviceType:         "WallSwitch",
+				Area:               "/area/3",
+				Zones: []*Zone{
+					{Href: "/zone/2", Name: "Lamp", ControlType: "Switched", Level: 100},
+				},
+			},
+			{
+				Href:               "/device/4",
+				FullyQualifiedName: []string{"Bedroom", "Shade"},
+				DeviceType:         "QsWirelessShade",
+				Area:               "/area/4",
+				Battery:            "Normal",
+				Zones: []*Zone{
+					{Href: "/zone/3", Name: "Shade", ControlType: "Shade", Level: 40},
+				},
+			},
+			{
+				Href:               "/device/5",
+				FullyQualifiedName: []string{"Kitchen", "Pico"},
+				DeviceType:         "Pico2Button",
+				Area:               "/area/2",
+				Battery:            "Normal",
+				Buttons: []*Button{
+					{
+						Href:         "/button/10",
+						Name:         "Button 1",
+						ButtonNumber: 0,
+						Preset: &Preset{
+							Href: "/preset/1",
+							Dimmed: []Assignment{
+								{Href: "/dimmedlevelassignment/1", Zone: "/zone/1", Level: 75, FadeTime: "00:00:02", DelayTime: "00:00:00"},
+							},
+						},
+					},
+					{
+						Href:         "/button/11",
+						Name:         "Button 2",
+						ButtonNumber: 1,
+						Preset: &Preset{
+							Href: "/preset/2",
+							Dimmed: []Assignment{
+								{Href: "/dimmedlevelassignment/2", Zone: "/zone/1", Level: 0, FadeTime: "00:00:02", DelayTime: "00:00:00"},
+							},
+						},
+					},
+				},
+			},
+		},
+		VirtualButtons: []*Button{
+			{
+				Href:         "/virtualbutton/1",
+				Name:         "Movie Night",
+				ButtonNumber: 0,
+				IsProgrammed: true,
+				Preset: &Preset{
+					Href: "/preset/3",
+					Dimmed: []Assignment{
+						{Href: "/dimmedlevelassignment/3", Zone: "/zone/1", Level: 10, FadeTime: "00:00:04", DelayTime: "00:00:00"},
+					},
+					Switched: []Assignment{
+						{Href: "/switchedlevelassignment/1", Zone: "/zone/2", Level: 0, DelayTime: "00:00:00"},
+					},
+				},
+			},
+			{
+				Href:         "/virtualbutton/2",
+				Name:         "Unused",
+				ButtonNumber: 1,
+			},
+		},
+		OccupancyGroups: []*OccupancyGroup{
+			{Href: "/occupancygroup/1", Areas: []string{"/area/2"}, Status: "Unoccupied"},
+		},
+	}
+}
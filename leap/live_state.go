@@ -0,0 +1,302 @@
+package leap
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/essentials"
+)
+
+type zoneStatusUpdate struct {
+	ZoneStatus   *ZoneStatus
+	ZoneStatuses []ZoneStatus
+}
+
+type buttonStatusUpdate struct {
+	ButtonStatus   *ButtonStatus
+	ButtonStatuses []ButtonStatus
+}
+
+// LiveState keeps an in-memory copy of device state which is kept up-to-date
+// by subscribing to zone, button, occupancy and device status on the broker
+// connection.
+//
+// Methods are safe to call concurrently from multiple Goroutines.
+type LiveState struct {
+	// Logger, if non-nil, receives errors which do not prevent
+	// synchronizing, such as subscriptions the bridge does not support.
+	// It should be set before the state is used.
+	Logger *log.Logger
+
+	// syncLock is held while synchronizing, so that concurrent callers do
+	// not all synchronize at once.
+	syncLock sync.Mutex
+
+	lock       sync.RWMutex
+	conn       BrokerConn
+	data       *deviceData
+	generation int
+	cancel     context.CancelFunc
+}
+
+func NewLiveState() *LiveState {
+	return &LiveState{}
+}
+
+// Devices returns the current devices, synchronizing with the connection if
+// the state is not yet tracking it.
+func (l *LiveState) Devices(ctx context.Context, conn BrokerConn, cache Cache) ([]*DeviceInfo, error) {
+	return liveView(ctx, l, conn, cache, (*deviceData).DeviceInfos, GetDevices)
+}
+
+// Occupancy returns the current occupancy groups, synchronizing with the
+// connection if the state is not yet tracking it.
+func (l *LiveState) Occupancy(ctx context.Context, conn BrokerConn, cache Cache) ([]*OccupancyInfo, error) {
+	return liveView(ctx, l, conn, cache, (*deviceData).OccupancyInfos, GetOccupancy)
+}
+
+// Areas returns the current area tree, synchronizing with the connection if
+// the state is not yet tracking it.
+func (l *LiveState) Areas(ctx context.Context, conn BrokerConn, cache Cache) ([]*AreaInfo, error) {
+	return liveView(ctx, l, conn, cache, (*deviceData).AreaInfos, GetAreas)
+}
+
+// liveView builds a view of the state, falling back to reading it from the
+// connection if the state cannot track the connection.
+func liveView[T any](
+	ctx context.Context,
+	l *LiveState,
+	conn BrokerConn,
+	cache Cache,
+	view func(*deviceData) T,
+	read func(context.Context, BrokerConn, Cache) (T, error),
+) (T, error) {
+	if res, ok := cachedView(l, conn, view); ok {
+		return res, nil
+	}
+	if err := l.Sync(ctx, conn, cache); err != nil {
+		var zero T
+		return zero, err
+	}
+	if res, ok := cachedView(l, conn, view); ok {
+		return res, nil
+	}
+	// The subscription died right after we synchronized, so we cannot trust
+	// the in-memory state.
+	return read(ctx, conn, cache)
+}
+
+// Sync subscribes to status updates on conn and reloads all device data.
+//
+// If the state is already tracking conn, this does nothing.
+func (l *LiveState) Sync(ctx context.Context, conn BrokerConn, cache Cache) (err error) {
+	defer essentials.AddCtxTo("sync live state", &err)
+
+	l.syncLock.Lock()
+	defer l.syncLock.Unlock()
+
+	if l.isTracking(conn) {
+		return nil
+	}
+
+	l.lock.Lock()
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.generation++
+	generation := l.generation
+	l.conn = nil
+	l.data = nil
+	subCtx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.lock.Unlock()
+
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+
+	// Subscribe before reading the rest of the state so that no zone updates
+	// are missed between the read and the subscription.
+	zoneUpdates, err := startSubscription[zoneStatusUpdate](ctx, subCtx, conn, "/zone/status")
+	if err != nil {
+		return err
+	}
+	initial := <-zoneUpdates
+	data, err := fetchDeviceData(ctx, conn, cache, initial.ZoneStatuses, l.Logger)
+	if err != nil {
+		return err
+	}
+
+	// Not all bridges support button status, so the state is still usable
+	// without it.
+	buttonUpdates, err := startSubscription[buttonStatusUpdate](ctx, subCtx, conn, "/button/status")
+	if err != nil {
+		logf(l.Logger, "not tracking button status: %v", err)
+	}
+	deviceUpdates, err := startSubscription[deviceStatusUpdate](ctx, subCtx, conn, "/device/status")
+	if err != nil {
+		logf(l.Logger, "not tracking device status: %v", err)
+	} else {
+		data.applyDeviceStatuses(<-deviceUpdates, false)
+	}
+	var occupancyUpdates <-chan occupancyStatusUpdate
+	if len(data.OccupancyGroups) > 0 {
+		occupancyUpdates, err = startSubscription[occupancyStatusUpdate](
+			ctx, subCtx, conn, "/occupancygroup/status",
+		)
+		if err != nil {
+			logf(l.Logger, "not tracking occupancy: %v", err)
+		} else {
+			// Catch up on changes since the statuses were read, though
+			// we don't know exactly when they happened.
+			data.applyOccupancy(<-occupancyUpdates, false)
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.generation != generation {
+		// Invalidate() was called while we were synchronizing.
+		cancel()
+		return nil
+	}
+	l.conn = conn
+	l.data = data
+	go applyUpdates(l, generation, zoneUpdates, l.applyZoneUpdate)
+	if buttonUpdates != nil {
+		<-buttonUpdates
+		go applyUpdates(l, generation, buttonUpdates, l.applyButtonUpdate)
+	}
+	if occupancyUpdates != nil {
+		go applyUpdates(l, generation, occupancyUpdates, l.applyOccupancyUpdate)
+	}
+	if deviceUpdates != nil {
+		go applyUpdates(l, generation, deviceUpdates, l.applyDeviceUpdate)
+	}
+	return nil
+}
+
+// Invalidate stops tracking the current connection, forcing the next call to
+// Devices() to synchronize again.
+func (l *LiveState) Invalidate() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.cancel != nil {
+		l.cancel()
+		l.cancel = nil
+	}
+	l.generation++
+	l.conn = nil
+	l.data = nil
+}
+
+func (l *LiveState) isTracking(conn BrokerConn) bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.conn == conn && l.data != nil
+}
+
+func cachedView[T any](l *LiveState, conn BrokerConn, view func(*deviceData) T) (T, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.conn != conn || l.data == nil {
+		var zero T
+		return zero, false
+	}
+	return view(l.data), true
+}
+
+// applyUpdates applies updates to the state until the subscription ends, at
+// which point the state stops tracking the connection.
+func applyUpdates[T any](
+	l *LiveState,
+	generation int,
+	updates <-chan T,
+	apply func(*deviceData, T),
+) {
+	for update := range updates {
+		l.lock.Lock()
+		if l.generation != generation {
+			l.lock.Unlock()
+			return
+		}
+		apply(l.data, update)
+		l.lock.Unlock()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.generation == generation {
+		l.cancel()
+		l.cancel = nil
+		l.generation++
+		l.conn = nil
+		l.data = nil
+	}
+}
+
+func (l *LiveState) applyZoneUpdate(data *deviceData, update zoneStatusUpdate) {
+	statuses := update.ZoneStatuses
+	if update.ZoneStatus != nil {
+		statuses = append(statuses, *update.ZoneStatus)
+	}
+	for _, status := range statuses {
+		// Zone updates don't mark the device as seen, since the bridge
+		// also sends them to echo commands, even for unreachable devices.
+		data.ZoneStatuses[status.Zone.Href] = status
+	}
+}
+
+func (l *LiveState) applyButtonUpdate(data *deviceData, update buttonStatusUpdate) {
+	statuses := update.ButtonStatuses
+	if update.ButtonStatus != nil {
+		statuses = append(statuses, *update.ButtonStatus)
+	}
+	for _, status := range statuses {
+		data.ButtonEvents[status.Button.Href] = &ButtonEvent{
+			EventType: status.ButtonEvent.EventType,
+			Time:      time.Now(),
+		}
+		data.markSeen(data.buttonDevices[status.Button.Href])
+	}
+}
+
+func (l *LiveState) applyDeviceUpdate(data *deviceData, update deviceStatusUpdate) {
+	data.applyDeviceStatuses(update, true)
+}
+
+func (l *LiveState) applyOccupancyUpdate(data *deviceData, update occupancyStatusUpdate) {
+	data.applyOccupancy(update, true)
+}
+
+// startSubscription calls SubscribeRequest with a subscription lifetime of
+// subCtx, but only waits for the initial response until ctx is done.
+//
+// If ctx is done first, the subscription continues in the background until
+// subCtx is done.
+func startSubscription[T any](
+	ctx context.Context,
+	subCtx context.Context,
+	conn BrokerConn,
+	url string,
+) (<-chan T, error) {
+	type result struct {
+		updates <-chan T
+		err     error
+	}
+	results := make(chan result, 1)
+	go func() {
+		updates, err := SubscribeRequest[T](subCtx, conn, url)
+		results <- result{updates: updates, err: err}
+	}()
+	select {
+	case res := <-results:
+		return res.updates, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,172 @@
+package leap_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestLiveStateUpdates(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	state := leap.NewLiveState()
+	defer state.Invalidate()
+	cache := leap.NewMemoryCache()
+	if err := state.Sync(ctx, broker, cache); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.SetZoneLevel("/zone/1", 80)
+	leaptest.WaitFor(t, func() bool {
+		devices, err := state.Devices(ctx, broker, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		level := findDevice(devices, "Island").Level
+		return level != nil && *level == 80
+	})
+
+	if err := leap.AckCreateRequest(ctx, broker, "/button/10/commandprocessor", map[string]any{
+		"Command": map[string]any{"CommandType": "PressAndRelease"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	leaptest.WaitFor(t, func() bool {
+		devices, _ := state.Devices(ctx, broker, cache)
+		button := findDevice(devices, "Pico").Buttons[0]
+		island := findDevice(devices, "Island")
+		return button.LastEvent != nil && button.LastEvent.EventType == "Release" &&
+			*island.Level == 75
+	})
+
+	if n := broker.RequestCount("/device"); n != 1 {
+		t.Errorf("expected one device read but got %d", n)
+	}
+}
+
+func TestLiveStateReconnect(t *testing.T) {
+	ctx := context.Background()
+	cache := leap.NewMemoryCache()
+	state := leap.NewLiveState()
+	defer state.Invalidate()
+
+	broker1 := leaptest.NewBroker(leaptest.SampleHouse())
+	if _, err := state.Devices(ctx, broker1, cache); err != nil {
+		t.Fatal(err)
+	}
+	broker1.Close()
+
+	broker2 := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker2.Close()
+	broker2.SetZoneLevel("/zone/1", 5)
+	devices, err := state.Devices(ctx, broker2, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := findDevice(devices, "Island").Level; level == nil || *level != 5 {
+		t.Errorf("unexpected level after reconnect: %v", level)
+	}
+	if n := broker2.RequestCount("/device"); n != 1 {
+		t.Errorf("expected new connection to be synchronized, got %d device reads", n)
+	}
+}
+
+func findDevice(devices []*leap.DeviceInfo, name string) *leap.DeviceInfo {
+	for _, d := range devices {
+		if d.FullyQualifiedName[len(d.FullyQualifiedName)-1] == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func TestLiveStateOccupancy(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	state := leap.NewLiveState()
+	defer state.Invalidate()
+	cache := leap.NewMemoryCache()
+	groups, err := state.Occupancy(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Href != "/occupancygroup/1" || groups[0].Status != "Unoccupied" ||
+		len(groups[0].Areas) != 1 || groups[0].Areas[0] != "/area/2" {
+		t.Fatalf("unexpected occupancy groups: %+v", groups[0])
+	}
+	if groups[0].LastOccupied != nil || groups[0].LastUnoccupied != nil {
+		t.Error("initial status should not be timestamped")
+	}
+
+	before := time.Now()
+	broker.SetOccupancy("/occupancygroup/1", "Occupied")
+	leaptest.WaitFor(t, func() bool {
+		groups, _ = state.Occupancy(ctx, broker, cache)
+		return groups[0].Status == "Occupied"
+	})
+	if groups[0].LastOccupied == nil || groups[0].LastOccupied.Before(before) {
+		t.Errorf("unexpected occupied time: %v", groups[0].LastOccupied)
+	}
+
+	devices, err := state.Devices(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if island := findDevice(devices, "Island"); island.Occupancy != "Occupied" {
+		t.Errorf("unexpected island occupancy: %q", island.Occupancy)
+	}
+	if lamp := findDevice(devices, "Lamp"); lamp.Occupancy != "" {
+		t.Errorf("unexpected lamp occupancy: %q", lamp.Occupancy)
+	}
+}
+
+func TestLiveStateDeviceStatus(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	state := leap.NewLiveState()
+	defer state.Invalidate()
+	cache := leap.NewMemoryCache()
+	devices, err := state.Devices(ctx, broker, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pico := findDevice(devices, "Pico")
+	if pico.Battery != "Normal" || pico.Availability != "Available" || pico.LastSeen != nil {
+		t.Fatalf("unexpected pico: %+v", pico)
+	}
+	if island := findDevice(devices, "Island"); island.Battery != "" || island.LastSeen != nil {
+		t.Fatalf("unexpected island: %+v", island)
+	}
+
+	// Zone updates may just echo commands, so they don't count as seeing
+	// the device.
+	broker.SetZoneLevel("/zone/1", 30)
+	leaptest.WaitFor(t, func() bool {
+		devices, _ = state.Devices(ctx, broker, cache)
+		level := findDevice(devices, "Island").Zones[0].Level
+		return level != nil && *level == 30
+	})
+	if island := findDevice(devices, "Island"); island.LastSeen != nil {
+		t.Errorf("unexpected island last seen: %v", island.LastSeen)
+	}
+
+	before := time.Now()
+	broker.SetDeviceStatus(pico.Href, "Low", "Unavailable")
+	leaptest.WaitFor(t, func() bool {
+		devices, _ = state.Devices(ctx, broker, cache)
+		return findDevice(devices, "Pico").Battery == "Low"
+	})
+	pico = findDevice(devices, "Pico")
+	if pico.Availability != "Unavailable" || pico.LastSeen == nil || pico.LastSeen.Before(before) {
+		t.Errorf("unexpected pico: %+v", pico)
+	}
+}
@@ -0,0 +1,138 @@
+package leap_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func newLocalServer(t *testing.T) *leaptest.LocalServer {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	server, err := leaptest.NewLocalServer(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		broker.Close()
+	})
+	return server
+}
+
+func TestLocalConn(t *testing.T) {
+	ctx := context.Background()
+	server := newLocalServer(t)
+	creds, err := server.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := leap.DialLocal(ctx, server.Addr, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	devices, err := leap.NewClient(conn, nil).DeviceInfos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 5 {
+		t.Errorf("expected 5 devices but got %d", len(devices))
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	updates, err := leap.SubscribeRequest[struct{ ZoneStatus *leap.ZoneStatus }](subCtx, conn, "/zone/1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveUpdate(t, updates)
+	server.Broker.SetZoneLevel("/zone/1", 33)
+	if update := receiveUpdate(t, updates); update.ZoneStatus == nil || update.ZoneStatus.Level != 33 {
+		t.Errorf("unexpected update: %+v", update)
+	}
+
+	server.CloseConnections()
+	leaptest.WaitFor(t, func() bool {
+		return conn.Error() != nil
+	})
+	var result any
+	if err := leap.ReadRequestWithRetry(ctx, conn, "/zone/1", &result, leap.NoRetry); err == nil {
+		t.Error("expected read on closed connection to fail")
+	}
+}
+
+func TestLocalConnUntrusted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	server := newLocalServer(t)
+	other := newLocalServer(t)
+
+	// The other bridge's certificate is not signed by our root.
+	creds, err := other.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := leap.DialLocal(ctx, server.Addr, creds); err == nil {
+		conn.Close()
+		t.Error("expected untrusted bridge to be rejected")
+	}
+
+	// Our bridge does not trust a certificate from the other bridge. With
+	// TLS 1.3, the client only finds out once it reads.
+	creds, err = server.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCreds, _ := other.Credentials()
+	creds.Certificate, creds.PrivateKey = otherCreds.Certificate, otherCreds.PrivateKey
+	if conn, err := leap.DialLocal(ctx, server.Addr, creds); err == nil {
+		defer conn.Close()
+		var result any
+		if err := leap.ReadRequestWithRetry(ctx, conn, "/device", &result, leap.NoRetry); err == nil {
+			t.Error("expected untrusted client to be rejected")
+		}
+	}
+}
+
+func TestPairLocal(t *testing.T) {
+	ctx := context.Background()
+	server := newLocalServer(t)
+
+	// Pairing should not finish until the button is pressed.
+	shortCtx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	_, err := leap.PairLocal(shortCtx, server.PairingAddr, server.PairingCredentials, "test")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout before button press but got %v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		server.PressButton()
+	}()
+	creds, err := leap.PairLocal(ctx, server.PairingAddr, server.PairingCredentials, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := leap.DialLocal(ctx, server.Addr, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leap.NewClient(conn, nil).Zones(ctx); err != nil {
+		t.Errorf("paired credentials were not accepted: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Error(); err != nil {
+		t.Errorf("expected no error after local Close but got %v", err)
+	}
+	if _, err := leap.NewClient(conn, nil).Zones(ctx); !errors.Is(err, leap.ErrConnClosed) {
+		t.Errorf("expected closed error after Close but got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package leap
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrConnClosed = errors.New("connection is closed")
+
+type hubSubscriber struct {
+	incoming chan<- Message
+	cancel   <-chan struct{}
+}
+
+// A MessageHub fans out incoming messages to subscribers.
+//
+// It implements the Subscribe(), Call(), and Error() semantics of BrokerConn
+// for connections which produce messages themselves rather than receiving
+// them from lutronbroker.
+type MessageHub struct {
+	subsLock sync.RWMutex
+	subs     map[*hubSubscriber]struct{}
+
+	doneLock sync.RWMutex
+	doneChan chan struct{}
+	doneErr  error
+}
+
+func NewMessageHub() *MessageHub {
+	return &MessageHub{
+		subs:     map[*hubSubscriber]struct{}{},
+		doneChan: make(chan struct{}),
+	}
+}
+
+// Subscribe is like BrokerConn.Subscribe().
+func (h *MessageHub) Subscribe(ctx context.Context, ch chan<- Message, f ...func() error) error {
+	sub := &hubSubscriber{incoming: ch, cancel: ctx.Done()}
+	h.subsLock.Lock()
+	h.subs[sub] = struct{}{}
+	h.subsLock.Unlock()
+
+	defer func() {
+		h.subsLock.Lock()
+		delete(h.subs, sub)
+		h.subsLock.Unlock()
+	}()
+
+	for _, cb := range f {
+		if err := cb(); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-h.doneChan:
+		return ErrConnClosed
+	}
+}
+
+// Call is like BrokerConn.Call(), using send to deliver the message once the
+// response listener is registered.
+func (h *MessageHub) Call(
+	ctx context.Context,
+	send func(Message) error,
+	msg Message,
+	f func(Message) (bool, error),
+) (Message, error) {
+	newCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	incoming := make(chan Message, 1)
+	subErr := make(chan error, 1)
+	go func() {
+		defer cancel()
+		subErr <- h.Subscribe(newCtx, incoming, func() error {
+			return send(msg)
+		})
+	}()
+
+	for {
+		select {
+		case response := <-incoming:
+			if ok, err := f(response); err != nil {
+				return Message{}, err
+			} else if ok {
+				return response, nil
+			}
+		case <-newCtx.Done():
+			if ctx.Err() != nil {
+				return Message{}, ctx.Err()
+			}
+			return Message{}, <-subErr
+		}
+	}
+}
+
+// Publish delivers a message to all current subscribers without blocking.
+func (h *MessageHub) Publish(msg Message) {
+	select {
+	case <-h.doneChan:
+		return
+	default:
+	}
+
+	h.subsLock.RLock()
+	defer h.subsLock.RUnlock()
+	for sub := range h.subs {
+		select {
+		case sub.incoming <- msg:
+		default:
+			go func(sub *hubSubscriber) {
+				select {
+				case sub.incoming <- msg:
+				case <-sub.cancel:
+				case <-h.doneChan:
+				}
+			}(sub)
+		}
+	}
+}
+
+// Done returns a channel which is closed once the hub is shut down.
+func (h *MessageHub) Done() <-chan struct{} {
+	return h.doneChan
+}
+
+// Shutdown stops all subscriptions. If err is non-nil, it will be returned by
+// Error().
+//
+// Returns false if the hub was already shut down.
+func (h *MessageHub) Shutdown(err error) bool {
+	h.doneLock.Lock()
+	defer h.doneLock.Unlock()
+	select {
+	case <-h.doneChan:
+		return false
+	default:
+	}
+	h.doneErr = err
+	close(h.doneChan)
+	return true
+}
+
+// Error returns the error passed to Shutdown(), if any.
+func (h *MessageHub) Error() error {
+	h.doneLock.RLock()
+	defer h.doneLock.RUnlock()
+	return h.doneErr
+}
@@ -0,0 +1,142 @@
+package leap
+
+import (
+	"context"
+	"time"
+
+	"github.com/unixpickle/essentials"
+)
+
+// OccupancyInfo is the state of an occupancy group.
+type OccupancyInfo struct {
+	Href   string
+	Areas  []string
+	Status string
+
+	// LastOccupied and LastUnoccupied are when the group last became
+	// occupied and unoccupied. They are only known for changes which
+	// happened while subscribed to occupancy updates.
+	LastOccupied   *time.Time `json:",omitempty"`
+	LastUnoccupied *time.Time `json:",omitempty"`
+}
+
+// occupancyState is the tracked state of an occupancy group.
+type occupancyState struct {
+	Status         string
+	LastOccupied   *time.Time
+	LastUnoccupied *time.Time
+}
+
+type occupancyStatusUpdate struct {
+	OccupancyGroupStatus   *OccupancyGroupStatus
+	OccupancyGroupStatuses []OccupancyGroupStatus
+}
+
+// GetOccupancy reads the occupancy groups and their statuses.
+func GetOccupancy(
+	ctx context.Context,
+	conn BrokerConn,
+	cache Cache,
+) (groups []*OccupancyInfo, err error) {
+	defer essentials.AddCtxTo("get occupancy", &err)
+	data := &deviceData{Occupancy: map[string]occupancyState{}}
+	if err := data.fetchOccupancy(ctx, conn); err != nil {
+		return nil, err
+	}
+	return data.OccupancyInfos(), nil
+}
+
+// fetchOccupancy reads the occupancy groups and their statuses. Bridges
+// without occupancy sensors may lack these resources, in which case there are
+// no groups.
+func (d *deviceData) fetchOccupancy(ctx context.Context, conn BrokerConn) error {
+	var groupsResponse struct {
+		OccupancyGroups []OccupancyGroup
+	}
+	if err := readOptional(ctx, conn, "/occupancygroup", &groupsResponse); err != nil {
+		return err
+	}
+	d.OccupancyGroups = groupsResponse.OccupancyGroups
+	if len(d.OccupancyGroups) == 0 {
+		return nil
+	}
+	var statusResponse occupancyStatusUpdate
+	if err := readOptional(ctx, conn, "/occupancygroup/status", &statusResponse); err != nil {
+		return err
+	}
+	d.applyOccupancy(statusResponse, false)
+	return nil
+}
+
+// applyOccupancy records occupancy statuses. If timestamp is true, changes
+// to a known status are timestamped with the current time.
+func (d *deviceData) applyOccupancy(update occupancyStatusUpdate, timestamp bool) {
+	statuses := update.OccupancyGroupStatuses
+	if update.OccupancyGroupStatus != nil {
+		statuses = append(statuses, *update.OccupancyGroupStatus)
+	}
+	now := time.Now()
+	for _, status := range statuses {
+		href := status.OccupancyGroup.Href
+		state, known := d.Occupancy[href]
+		if known && state.Status == status.OccupancyStatus {
+			continue
+		}
+		state.Status = status.OccupancyStatus
+		if known && timestamp {
+			switch state.Status {
+			case "Occupied":
+				state.LastOccupied = &now
+			case "Unoccupied":
+				state.LastUnoccupied = &now
+			}
+		}
+		d.Occupancy[href] = state
+	}
+}
+
+// OccupancyInfos lists the occupancy groups with their current state.
+func (d *deviceData) OccupancyInfos() []*OccupancyInfo {
+	var res []*OccupancyInfo
+	for _, group := range d.OccupancyGroups {
+		state := d.Occupancy[group.Href]
+		info := &OccupancyInfo{
+			Href:           group.Href,
+			Areas:          []string{},
+			Status:         state.Status,
+			LastOccupied:   state.LastOccupied,
+			LastUnoccupied: state.LastUnoccupied,
+		}
+		if info.Status == "" {
+			info.Status = "Unknown"
+		}
+		for _, area := range group.AssociatedAreas {
+			info.Areas = append(info.Areas, area.Area.Href)
+		}
+		res = append(res, info)
+	}
+	return res
+}
+
+// areaOccupancy combines the statuses of an area's occupancy groups, which
+// is "Occupied" if any group is occupied. It returns "" if the area has no
+// occupancy groups.
+func (d *deviceData) areaOccupancy(areaHref string) string {
+	var result string
+	for _, group := range d.OccupancyGroups {
+		for _, area := range group.AssociatedAreas {
+			if area.Area.Href != areaHref {
+				continue
+			}
+			switch status := d.Occupancy[group.Href].Status; {
+			case status == "Occupied":
+				return status
+			case status == "Unoccupied":
+				result = status
+			case result == "":
+				result = "Unknown"
+			}
+		}
+	}
+	return result
+}
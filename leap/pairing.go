@@ -0,0 +1,156 @@
+package leap
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"slices"
+
+	"github.com/unixpickle/essentials"
+)
+
+// pairingMessage is the format of messages on the pairing port, which
+// differs from regular LEAP messages.
+type pairingMessage struct {
+	Header struct {
+		StatusCode  string `json:",omitempty"`
+		ContentType string `json:",omitempty"`
+		RequestType string `json:",omitempty"`
+		Url         string `json:",omitempty"`
+		ClientTag   string `json:",omitempty"`
+	}
+	Body struct {
+		Status *struct {
+			Permissions []string
+		} `json:",omitempty"`
+		SigningResult *struct {
+			Certificate     string
+			RootCertificate string
+		} `json:",omitempty"`
+		CommandType string          `json:",omitempty"`
+		Parameters  *pairingRequest `json:",omitempty"`
+	}
+}
+
+type pairingRequest struct {
+	CSR         string
+	DisplayName string
+	DeviceUID   string
+	Role        string
+}
+
+// PairLocal obtains credentials for connecting directly to the bridge at
+// addr, which may omit the port to use PairingPort.
+//
+// The pairing credentials are the ones which Lutron's apps use to pair, and
+// name is shown in the bridge's list of integrations.
+//
+// The bridge only responds once somebody presses the button on the back of
+// it, so ctx should allow a few minutes.
+func PairLocal(
+	ctx context.Context,
+	addr string,
+	pairing *LocalCredentials,
+	name string,
+) (creds *LocalCredentials, err error) {
+	defer essentials.AddCtxTo("pair with local bridge", &err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := pairing.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", withDefaultPort(addr, PairingPort))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	readMessage := func() (*pairingMessage, error) {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		var msg pairingMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, err
+		}
+		if code, status := parseStatusCode(msg.Header.StatusCode); code >= 300 {
+			return nil, fmt.Errorf("bridge responded with status %d %s", code, status)
+		}
+		return &msg, nil
+	}
+
+	for {
+		msg, err := readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if msg.Body.Status != nil && slices.Contains(msg.Body.Status.Permissions, "PhysicalAccess") {
+			break
+		}
+	}
+
+	var request pairingMessage
+	request.Header.RequestType = "Execute"
+	request.Header.Url = "/pair"
+	request.Header.ClientTag = "get-cert"
+	request.Body.CommandType = "CSR"
+	request.Body.Parameters = &pairingRequest{
+		CSR:         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		DisplayName: name,
+		DeviceUID:   "000000000000",
+		Role:        "Admin",
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(data, '\r', '\n')); err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if result := msg.Body.SigningResult; result != nil {
+			keyPEM := pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: x509.MarshalPKCS1PrivateKey(key),
+			})
+			return &LocalCredentials{
+				Certificate:     result.Certificate,
+				PrivateKey:      string(keyPEM),
+				RootCertificate: result.RootCertificate,
+			}, nil
+		}
+	}
+}
@@ -1,7 +1,9 @@
-package main
+package leap
 
 import (
 	"context"
+	"errors"
+	"log"
 
 	"github.com/unixpickle/essentials"
 )
@@ -12,29 +14,29 @@ type rawProgrammingModel struct {
 	Href                 string `json:"href"`
 	ProgrammingModelType string
 	Direction            *string
-	Preset               *rawLink
+	Preset               *Link
 	DualActionProperties *struct {
-		PressPreset   rawLink
-		ReleasePreset rawLink
+		PressPreset   Link
+		ReleasePreset Link
 	}
 }
 
 type rawPresetInner struct {
 	Href                     string `json:"href"`
-	DimmedLevelAssignment    *rawLink
-	DimmedLevelAssignments   []rawLink
-	SwitchedLevelAssignment  *rawLink
-	SwitchedLevelAssignments []rawLink
+	DimmedLevelAssignment    *Link
+	DimmedLevelAssignments   []Link
+	SwitchedLevelAssignment  *Link
+	SwitchedLevelAssignments []Link
 }
 
-func (r *rawPresetInner) AllDimmedLevelAssignments() []rawLink {
+func (r *rawPresetInner) AllDimmedLevelAssignments() []Link {
 	if r.DimmedLevelAssignment != nil {
 		return append(r.DimmedLevelAssignments, *r.DimmedLevelAssignment)
 	}
 	return r.DimmedLevelAssignments
 }
 
-func (r *rawPresetInner) AllSwitchedLevelAssignments() []rawLink {
+func (r *rawPresetInner) AllSwitchedLevelAssignments() []Link {
 	if r.SwitchedLevelAssignment != nil {
 		return append(r.SwitchedLevelAssignments, *r.SwitchedLevelAssignment)
 	}
@@ -90,10 +92,20 @@ type Preset struct {
 	SwitchedLevelAssignments []SwitchedLevelAssignment
 }
 
-func GetProgrammingModels(
+// GetProgrammingModels reads every programming model along with its presets,
+// keyed by href. Presets which fail to load are left out, and are fetched
+// again next time.
+func GetProgrammingModels(ctx context.Context, conn BrokerConn, cache Cache) (map[string]*ProgrammingModel, error) {
+	return getProgrammingModels(ctx, conn, cache, nil)
+}
+
+// getProgrammingModels is like GetProgrammingModels, but also reports presets
+// which failed to load to logger, if it is non-nil.
+func getProgrammingModels(
 	ctx context.Context,
 	conn BrokerConn,
 	cache Cache,
+	logger *log.Logger,
 ) (models map[string]*ProgrammingModel, err error) {
 	defer essentials.AddCtxTo("get programming models", &err)
 
@@ -121,14 +133,22 @@ func GetProgrammingModels(
 	}
 
 	if len(allPresetURLs) > 0 {
-		if newPresets, err := fetchNewPresets(ctx, conn, allPresetURLs); err != nil {
-			return nil, err
-		} else {
+		newPresets, err := fetchNewPresets(ctx, conn, allPresetURLs)
+		if err != nil {
+			// Presets that failed to load are left out of the cache so that
+			// they will be fetched again next time.
+			var batchErr *BatchError
+			if !errors.As(err, &batchErr) || ctx.Err() != nil {
+				return nil, err
+			}
+			logf(logger, "partially failed to fetch presets: %v", err)
+		}
+		if len(newPresets) > 0 {
 			for k, v := range newPresets {
 				presetMap[k] = v
 			}
+			cache.SetCache(CachePresetKey, presetMap)
 		}
-		cache.SetCache(CachePresetKey, presetMap)
 	}
 
 	results := map[string]*ProgrammingModel{}
@@ -150,13 +170,36 @@ func GetProgrammingModels(
 	return results, nil
 }
 
+// fetchNewPresets fetches presets and their level assignments.
+//
+// If some requests fail, a *BatchError is returned along with every preset
+// that was fetched completely.
 func fetchNewPresets(
 	ctx context.Context,
 	conn BrokerConn,
 	allPresetURLs map[string]struct{},
 ) (map[string]*Preset, error) {
-	presets, err := ReadRequestsAsMap[rawPreset](ctx, conn, allPresetURLs)
-	if err != nil {
+	opts := DefaultBatchOptions
+	opts.CancelOnError = false
+
+	batchErr := &BatchError{Failures: map[string]error{}}
+	addFailures := func(total int, err error) error {
+		batchErr.Total += total
+		if err == nil {
+			return nil
+		}
+		var e *BatchError
+		if !errors.As(err, &e) {
+			return err
+		}
+		for k, v := range e.Failures {
+			batchErr.Failures[k] = v
+		}
+		return nil
+	}
+
+	presets, err := ReadRequestsAsMapWithOptions[rawPreset](ctx, conn, allPresetURLs, opts)
+	if err := addFailures(len(allPresetURLs), err); err != nil {
 		return nil, err
 	}
 
@@ -170,16 +213,16 @@ func fetchNewPresets(
 			allSwitchedLevelAssignmentURLs[x.Href] = struct{}{}
 		}
 	}
-	dimmedLevelAssignments, err := ReadRequestsAsMap[rawDimmedLevelAssignment](
-		ctx, conn, allDimmedLevelAssignmentURLs,
+	dimmedLevelAssignments, err := ReadRequestsAsMapWithOptions[rawDimmedLevelAssignment](
+		ctx, conn, allDimmedLevelAssignmentURLs, opts,
 	)
-	if err != nil {
+	if err := addFailures(len(allDimmedLevelAssignmentURLs), err); err != nil {
 		return nil, err
 	}
-	switchedLevelAssignments, err := ReadRequestsAsMap[rawSwitchedLevelAssignment](
-		ctx, conn, allSwitchedLevelAssignmentURLs,
+	switchedLevelAssignments, err := ReadRequestsAsMapWithOptions[rawSwitchedLevelAssignment](
+		ctx, conn, allSwitchedLevelAssignmentURLs, opts,
 	)
-	if err != nil {
+	if err := addFailures(len(allSwitchedLevelAssignmentURLs), err); err != nil {
 		return nil, err
 	}
 
@@ -187,16 +230,24 @@ func fetchNewPresets(
 	for url, rawPreset := range presets {
 		dimmed := []DimmedLevelAssignment{}
 		switched := []SwitchedLevelAssignment{}
+		complete := true
 		for _, d := range rawPreset.Preset.AllDimmedLevelAssignments() {
 			if d1, ok := dimmedLevelAssignments[d.Href]; ok {
 				dimmed = append(dimmed, DimmedLevelAssignment(d1.DimmedLevelAssignment))
+			} else if _, failed := batchErr.Failures[d.Href]; failed {
+				complete = false
 			}
 		}
 		for _, s := range rawPreset.Preset.AllSwitchedLevelAssignments() {
 			if s1, ok := switchedLevelAssignments[s.Href]; ok {
 				switched = append(switched, SwitchedLevelAssignment(s1.SwitchedLevelAssignment))
+			} else if _, failed := batchErr.Failures[s.Href]; failed {
+				complete = false
 			}
 		}
+		if !complete {
+			continue
+		}
 		results[url] = &Preset{
 			Href:                     rawPreset.Preset.Href,
 			DimmedLevelAssignments:   dimmed,
@@ -204,5 +255,8 @@ func fetchNewPresets(
 		}
 	}
 
+	if len(batchErr.Failures) > 0 {
+		return results, batchErr
+	}
 	return results, nil
 }
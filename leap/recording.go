@@ -0,0 +1,274 @@
+package leap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/essentials"
+)
+
+const (
+	DirectionSend    = "send"
+	DirectionReceive = "receive"
+)
+
+// A RecordedMessage is one line of a recording file.
+type RecordedMessage struct {
+	Time      time.Time
+	Direction string
+	Message   Message
+}
+
+// A Recorder appends BrokerConn traffic to a JSONL file.
+//
+// A single Recorder may wrap multiple connections over time (e.g. across
+// reconnects), and is safe to use from multiple Goroutines.
+type Recorder struct {
+	lock sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// NewRecorder opens a recording file for appending.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Wrap returns a BrokerConn that records all messages sent and received by
+// conn.
+func (r *Recorder) Wrap(conn BrokerConn) BrokerConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := &recordingConn{
+		BrokerConn: conn,
+		recorder:   r,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	ch := make(chan Message, 16)
+	subscribed := make(chan struct{})
+	go func() {
+		defer cancel()
+		conn.Subscribe(ctx, ch, func() error {
+			close(subscribed)
+			return nil
+		})
+	}()
+	go func() {
+		defer close(rc.done)
+		for {
+			select {
+			case msg := <-ch:
+				r.record(DirectionReceive, msg)
+			case <-ctx.Done():
+				// Record anything that arrived before we stopped.
+				for {
+					select {
+					case msg := <-ch:
+						r.record(DirectionReceive, msg)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	select {
+	case <-subscribed:
+	case <-ctx.Done():
+	}
+	return rc
+}
+
+// Close flushes and closes the underlying file.
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+func (r *Recorder) record(direction string, msg Message) {
+	data, err := json.Marshal(RecordedMessage{
+		Time:      time.Now(),
+		Direction: direction,
+		Message:   msg,
+	})
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.w.Write(data)
+	r.w.WriteByte('\n')
+	r.w.Flush()
+}
+
+type recordingConn struct {
+	BrokerConn
+	recorder *Recorder
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func (r *recordingConn) Send(msg Message) error {
+	r.recorder.record(DirectionSend, msg)
+	return r.BrokerConn.Send(msg)
+}
+
+func (r *recordingConn) Call(
+	ctx context.Context,
+	msg Message,
+	f func(Message) (bool, error),
+) (Message, error) {
+	r.recorder.record(DirectionSend, msg)
+	return r.BrokerConn.Call(ctx, msg, f)
+}
+
+func (r *recordingConn) Close() error {
+	r.cancel()
+	<-r.done
+	return r.BrokerConn.Close()
+}
+
+// LoadRecording reads all of the messages from a recording file.
+func LoadRecording(path string) (records []RecordedMessage, err error) {
+	defer essentials.AddCtxTo("load recording", &err)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<26)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+type replayKey struct {
+	CommuniqueType string
+	Url            string
+}
+
+// ReplayConn is a BrokerConn which answers requests with responses from a
+// recording.
+//
+// Requests are matched to recorded requests by CommuniqueType and Url. If a
+// request is made more times than it was recorded, the last recorded response
+// is reused.
+type ReplayConn struct {
+	hub *MessageHub
+
+	lock      sync.Mutex
+	responses map[replayKey][]Message
+	counts    map[replayKey]int
+}
+
+// NewReplayConn creates a ReplayConn from recorded messages.
+func NewReplayConn(records []RecordedMessage) *ReplayConn {
+	tagToKey := map[string]replayKey{}
+	responses := map[replayKey][]Message{}
+	for _, record := range records {
+		msg := record.Message
+		if record.Direction == DirectionSend {
+			key := replayKey{CommuniqueType: msg.CommuniqueType, Url: msg.Header.Url}
+			tagToKey[msg.Header.ClientTag] = key
+			if _, ok := responses[key]; !ok {
+				responses[key] = nil
+			}
+		} else if key, ok := tagToKey[msg.Header.ClientTag]; ok && msg.Header.ClientTag != "" {
+			responses[key] = append(responses[key], msg)
+			delete(tagToKey, msg.Header.ClientTag)
+		}
+	}
+	return &ReplayConn{
+		hub:       NewMessageHub(),
+		responses: responses,
+		counts:    map[replayKey]int{},
+	}
+}
+
+// Send looks up a recorded response to msg and delivers it asynchronously.
+//
+// If no matching request was recorded, the response is a 404 error. If a
+// matching request was recorded without a response, no response is sent.
+func (r *ReplayConn) Send(msg Message) (err error) {
+	defer essentials.AddCtxTo("send to replay", &err)
+
+	select {
+	case <-r.hub.Done():
+		return ErrConnClosed
+	default:
+	}
+
+	key := replayKey{CommuniqueType: msg.CommuniqueType, Url: msg.Header.Url}
+	r.lock.Lock()
+	responses, ok := r.responses[key]
+	idx := r.counts[key]
+	r.counts[key]++
+	r.lock.Unlock()
+
+	var response Message
+	if !ok {
+		body, _ := json.Marshal(map[string]string{
+			"Message": fmt.Sprintf("no recorded %s for %s", msg.CommuniqueType, msg.Header.Url),
+		})
+		response = Message{
+			CommuniqueType: "ExceptionResponse",
+			Header: Header{
+				Url:             msg.Header.Url,
+				StatusCode:      "404 NotFound",
+				MessageBodyType: "ExceptionDetail",
+			},
+			Body: body,
+		}
+	} else if len(responses) == 0 {
+		return nil
+	} else {
+		response = responses[min(idx, len(responses)-1)]
+	}
+	response.Header.ClientTag = msg.Header.ClientTag
+	go r.hub.Publish(response)
+	return nil
+}
+
+func (r *ReplayConn) Subscribe(ctx context.Context, ch chan<- Message, f ...func() error) error {
+	return r.hub.Subscribe(ctx, ch, f...)
+}
+
+func (r *ReplayConn) Call(ctx context.Context, msg Message, f func(Message) (bool, error)) (Message, error) {
+	return r.hub.Call(ctx, r.Send, msg, f)
+}
+
+func (r *ReplayConn) Close() error {
+	if !r.hub.Shutdown(nil) {
+		return ErrConnClosed
+	}
+	return nil
+}
+
+func (r *ReplayConn) Error() error {
+	return r.hub.Error()
+}
@@ -0,0 +1,75 @@
+package leap_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	recorder, err := leap.NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	conn := recorder.Wrap(broker)
+	expected, err := leap.GetDevices(ctx, conn, leap.NewMemoryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := leap.LoadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numSent, numReceived int
+	for _, record := range records {
+		if record.Direction == leap.DirectionSend {
+			numSent++
+		} else if record.Direction == leap.DirectionReceive {
+			numReceived++
+		}
+	}
+	if numSent == 0 || numSent != numReceived {
+		t.Fatalf("expected matching sends and receives, got %d and %d", numSent, numReceived)
+	}
+
+	replay := leap.NewReplayConn(records)
+	actual, err := leap.GetDevices(ctx, replay, leap.NewMemoryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedJSON, _ := json.Marshal(expected)
+	actualJSON, _ := json.Marshal(actual)
+	if !reflect.DeepEqual(expectedJSON, actualJSON) {
+		t.Errorf("replay mismatch:\nexpected: %s\nactual: %s", expectedJSON, actualJSON)
+	}
+
+	var result any
+	if err := leap.ReadRequest(ctx, replay, "/zone/99", &result); !errors.Is(err, leap.ErrNotFound) {
+		t.Errorf("expected not found error for unrecorded URL but got %v", err)
+	}
+
+	if err := replay.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.Error(); err != nil {
+		t.Errorf("expected no error after local Close but got %v", err)
+	}
+	if err := replay.Send(leap.Message{CommuniqueType: "ReadRequest"}); !errors.Is(err, leap.ErrConnClosed) {
+		t.Errorf("expected closed error from Send after Close but got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package leap
+
+// A Link refers to another resource by its URL.
+type Link struct {
+	Href string `json:"href"`
+}
+
+// A Device is a physical device, such as a dimmer, a Pico remote, or the
+// bridge itself.
+type Device struct {
+	Href               string `json:"href"`
+	Name               string
+	FullyQualifiedName []string
+	DeviceType         string
+	LocalZones         []Link
+	AssociatedArea     *Link
+	ButtonGroups       []Link
+
+	SerialNumber  int
+	ModelNumber   string
+	FirmwareImage *FirmwareImage
+
+	// AddressedState is "Addressed" once a device has been added to the
+	// system, and "Unaddressed" or "Unconfigured" otherwise.
+	AddressedState string
+}
+
+// A FirmwareImage describes the firmware installed on a device.
+type FirmwareImage struct {
+	Firmware struct {
+		DisplayName string
+	}
+}
+
+// DeviceStatus is the health of a device.
+type DeviceStatus struct {
+	Href          string `json:"href"`
+	Device        Link
+	BatteryStatus *BatteryStatus `json:",omitempty"`
+
+	// Availability is "Available" if the bridge can reach the device, and
+	// "Unavailable" if it cannot.
+	Availability string `json:",omitempty"`
+}
+
+// BatteryStatus is the battery level of a battery-powered device, which is
+// "Normal", "Low", "Empty" or "Unknown".
+type BatteryStatus struct {
+	LevelState string
+}
+
+// A Zone is a controllable output, such as a light or a shade.
+type Zone struct {
+	Href           string `json:"href"`
+	Name           string
+	ControlType    string
+	Device         *Link
+	AssociatedArea *Link
+
+	// ColorTuningProperties describes the colors which a tunable zone
+	// supports, if the bridge reports them.
+	ColorTuningProperties *ColorTuningProperties `json:",omitempty"`
+}
+
+// ColorTuningProperties describes the color capabilities of a zone.
+type ColorTuningProperties struct {
+	WhiteTuningLevelRange *KelvinRange `json:",omitempty"`
+}
+
+// A KelvinRange is a range of color temperatures.
+type KelvinRange struct {
+	Min int
+	Max int
+}
+
+// ZoneStatus is the current state of a zone.
+type ZoneStatus struct {
+	Href           string `json:"href"`
+	Level          int
+	Zone           Link
+	StatusAccuracy string
+
+	// FanSpeed is set instead of Level for fan zones.
+	FanSpeed string `json:",omitempty"`
+
+	// Tilt is set for shades which can tilt.
+	Tilt *int `json:",omitempty"`
+
+	// ColorTuningStatus and Vibrancy are set for color tunable zones.
+	ColorTuningStatus *ColorTuningStatus `json:",omitempty"`
+	Vibrancy          *int               `json:",omitempty"`
+
+	// The remaining fields are set for HVAC zones, with temperatures in
+	// Celsius.
+	CurrentTemperature *float64 `json:",omitempty"`
+	HeatSetPoint       *float64 `json:",omitempty"`
+	CoolSetPoint       *float64 `json:",omitempty"`
+	OperatingMode      string   `json:",omitempty"`
+	FanMode            string   `json:",omitempty"`
+	ScheduleStatus     string   `json:",omitempty"`
+}
+
+// ColorTuningStatus is the color of a tunable zone, which is either a color
+// temperature or a hue and saturation.
+type ColorTuningStatus struct {
+	WhiteTuningLevel *WhiteTuningLevel `json:",omitempty"`
+	HSVTuningLevel   *HSVTuningLevel   `json:",omitempty"`
+}
+
+// WhiteTuningLevel is a color temperature in kelvin.
+type WhiteTuningLevel struct {
+	Kelvin int
+}
+
+// HSVTuningLevel is a color, with a hue in degrees and a saturation from 0
+// to 100.
+type HSVTuningLevel struct {
+	Hue        int
+	Saturation int
+}
+
+// An Area is a room or group of rooms. Areas form a tree through their
+// Parent links.
+type Area struct {
+	Href   string `json:"href"`
+	Name   string
+	Parent *Link
+	IsLeaf bool
+}
+
+// An OccupancyGroup is a set of occupancy sensors which together report
+// whether their areas are occupied.
+type OccupancyGroup struct {
+	Href            string `json:"href"`
+	AssociatedAreas []OccupancyGroupArea
+}
+
+// An OccupancyGroupArea links an occupancy group to one of its areas.
+type OccupancyGroupArea struct {
+	Area Link
+}
+
+// OccupancyGroupStatus is the occupancy of a group, which is "Occupied",
+// "Unoccupied" or "Unknown".
+type OccupancyGroupStatus struct {
+	Href            string `json:"href"`
+	OccupancyGroup  Link
+	OccupancyStatus string
+}
+
+// A Button is a physical button on a keypad or remote.
+type Button struct {
+	Href             string `json:"href"`
+	Name             string
+	ButtonNumber     int
+	Parent           Link
+	ProgrammingModel Link
+}
+
+// ButtonStatus is the most recent event of a button.
+type ButtonStatus struct {
+	Button      Link
+	ButtonEvent struct {
+		EventType string
+	}
+}
+
+// A VirtualButton is a button which only exists in software, and is used to
+// implement scenes.
+type VirtualButton struct {
+	Href             string `json:"href"`
+	Name             string
+	ButtonNumber     int
+	IsProgrammed     bool
+	ProgrammingModel *Link `json:",omitempty"`
+}
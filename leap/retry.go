@@ -0,0 +1,101 @@
+package leap
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/unixpickle/lutronbroker/lutronbroker"
+)
+
+// A RetryPolicy determines how requests are retried after transient errors
+// such as timeouts and connection errors.
+//
+// Errors reported by the bridge itself (see LEAPError) and closed connections
+// are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values less than one are treated as one.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the second attempt. The delay is
+	// doubled for each subsequent attempt, up to MaxBackoff, and a random
+	// jitter of up to half the delay is subtracted.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// AttemptTimeout, if non-zero, limits the duration of each attempt.
+	AttemptTimeout time.Duration
+}
+
+// DefaultRetryPolicy is used by ReadRequest() and ReadRequests().
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond * 250,
+	MaxBackoff:     time.Second * 2,
+	AttemptTimeout: time.Second * 5,
+}
+
+// NoRetry makes a single attempt with no per-attempt timeout.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// Do calls f until it succeeds, it returns a permanent error, the attempts
+// are exhausted, or ctx is done.
+//
+// The context passed to f is limited by AttemptTimeout.
+func (r RetryPolicy) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < max(1, r.MaxAttempts); attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(r.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		}
+		err = r.attempt(ctx, f)
+		if err == nil || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (r RetryPolicy) attempt(ctx context.Context, f func(ctx context.Context) error) error {
+	if r.AttemptTimeout == 0 {
+		return f(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.AttemptTimeout)
+	defer cancel()
+	return f(ctx)
+}
+
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	delay := r.InitialBackoff
+	for i := 1; i < attempt && (r.MaxBackoff == 0 || delay < r.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if r.MaxBackoff != 0 && delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay - rand.N(delay/2+1)
+}
+
+// IsRetryable returns false for errors that will not go away by repeating a
+// request, such as errors reported by the bridge, closed connections, and
+// cancellations.
+func IsRetryable(err error) bool {
+	var leapErr *LEAPError
+	if errors.As(err, &leapErr) {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, ErrConnClosed) &&
+		!errors.Is(err, lutronbroker.ErrClosed)
+}
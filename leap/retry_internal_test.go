@@ -0,0 +1,23 @@
+package leap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Second * 3}
+	for attempt, maxDelay := range map[int]time.Duration{
+		1: time.Second,
+		2: time.Second * 2,
+		3: time.Second * 3,
+		8: time.Second * 3,
+	} {
+		for i := 0; i < 100; i++ {
+			delay := policy.backoff(attempt)
+			if delay > maxDelay || delay < maxDelay/2 {
+				t.Fatalf("attempt %d: delay %s out of range", attempt, delay)
+			}
+		}
+	}
+}
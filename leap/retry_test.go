@@ -0,0 +1,71 @@
+package leap_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestReadRequestRetry(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	policy := leap.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond * 5,
+		AttemptTimeout: time.Millisecond * 50,
+	}
+
+	broker.DropResponses("/zone/1", 2)
+	var result any
+	if err := leap.ReadRequestWithRetry(ctx, broker, "/zone/1", &result, policy); err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.RequestCount("/zone/1"); n != 3 {
+		t.Errorf("expected 3 attempts but got %d", n)
+	}
+
+	broker.DropResponses("/zone/2", 3)
+	err := leap.ReadRequestWithRetry(ctx, broker, "/zone/2", &result, policy)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout after exhausting attempts but got %v", err)
+	}
+
+	err = leap.ReadRequestWithRetry(ctx, broker, "/zone/99", &result, policy)
+	if !errors.Is(err, leap.ErrNotFound) {
+		t.Errorf("expected not found error but got %v", err)
+	}
+	if n := broker.RequestCount("/zone/99"); n != 1 {
+		t.Errorf("LEAP errors should not be retried, but got %d attempts", n)
+	}
+
+	broker.Close()
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+	err = leap.ReadRequestWithRetry(ctx, broker, "/zone/1", &result, policy)
+	if !errors.Is(err, leap.ErrConnClosed) || leap.IsRetryable(err) {
+		t.Errorf("expected permanent closed error but got %v", err)
+	}
+}
+
+func TestAckCreateRequestNotRetried(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	broker.DropResponses("/button/10/commandprocessor", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	body := map[string]any{"Command": map[string]any{"CommandType": "PressAndRelease"}}
+	if err := leap.AckCreateRequest(ctx, broker, "/button/10/commandprocessor", body); err == nil {
+		t.Fatal("expected timeout")
+	}
+	if n := broker.RequestCount("/button/10/commandprocessor"); n != 1 {
+		t.Errorf("commands should not be retried, but got %d attempts", n)
+	}
+}
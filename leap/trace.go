@@ -0,0 +1,264 @@
+package leap
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TraceEntry is a request and its response, or a message which the bridge
+// sent on its own, such as a subscription update.
+type TraceEntry struct {
+	ID             int64
+	Time           time.Time
+	ClientTag      string
+	CommuniqueType string
+	Url            string
+	Request        *Message      `json:",omitempty"`
+	Response       *Message      `json:",omitempty"`
+	Latency        time.Duration `json:",omitempty"`
+	Error          string        `json:",omitempty"`
+
+	// Done is false while a request is waiting for a response.
+	Done bool
+}
+
+// TraceFilter selects trace entries. Empty fields match everything.
+type TraceFilter struct {
+	UrlPrefix string
+
+	// CommuniqueType matches the type of either the request or the response.
+	CommuniqueType string
+}
+
+func (t TraceFilter) Match(e *TraceEntry) bool {
+	if !strings.HasPrefix(e.Url, t.UrlPrefix) {
+		return false
+	}
+	if t.CommuniqueType == "" {
+		return true
+	}
+	return (e.Request != nil && e.Request.CommuniqueType == t.CommuniqueType) ||
+		(e.Response != nil && e.Response.CommuniqueType == t.CommuniqueType)
+}
+
+// A Trace keeps the most recent message exchanges of wrapped connections in
+// a ring buffer.
+//
+// Methods are safe to call concurrently from multiple Goroutines.
+type Trace struct {
+	lock    sync.Mutex
+	entries []*TraceEntry
+	start   int
+	lastID  int64
+	pending map[string]*TraceEntry
+	tails   map[chan TraceEntry]struct{}
+}
+
+// NewTrace creates a Trace which holds up to size entries.
+func NewTrace(size int) *Trace {
+	return &Trace{
+		entries: make([]*TraceEntry, 0, size),
+		pending: map[string]*TraceEntry{},
+		tails:   map[chan TraceEntry]struct{}{},
+	}
+}
+
+// Wrap returns a BrokerConn that traces all messages sent and received by
+// conn.
+func (t *Trace) Wrap(conn BrokerConn) BrokerConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	tc := &tracingConn{BrokerConn: conn, trace: t, cancel: cancel}
+	ch := make(chan Message, 16)
+	subscribed := make(chan struct{})
+	go func() {
+		defer cancel()
+		conn.Subscribe(ctx, ch, func() error {
+			close(subscribed)
+			return nil
+		})
+	}()
+	go func() {
+		for {
+			select {
+			case msg := <-ch:
+				t.receive(msg)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	select {
+	case <-subscribed:
+	case <-ctx.Done():
+	}
+	return tc
+}
+
+// Entries returns the entries matching a filter, oldest first.
+func (t *Trace) Entries(filter TraceFilter) []TraceEntry {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var res []TraceEntry
+	for i := range t.entries {
+		e := t.entries[(t.start+i)%len(t.entries)]
+		if filter.Match(e) {
+			res = append(res, *e)
+		}
+	}
+	return res
+}
+
+// Tail returns a channel of entries as they are completed, until ctx is
+// done.
+//
+// If the receiver falls behind, entries are dropped rather than slowing down
+// the connection.
+func (t *Trace) Tail(ctx context.Context) <-chan TraceEntry {
+	ch := make(chan TraceEntry, 64)
+	t.lock.Lock()
+	t.tails[ch] = struct{}{}
+	t.lock.Unlock()
+	go func() {
+		<-ctx.Done()
+		t.lock.Lock()
+		delete(t.tails, ch)
+		t.lock.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// send adds an entry for an outgoing request.
+func (t *Trace) send(msg Message) *TraceEntry {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	e := &TraceEntry{
+		Time:           time.Now(),
+		ClientTag:      msg.Header.ClientTag,
+		CommuniqueType: msg.CommuniqueType,
+		Url:            msg.Header.Url,
+		Request:        &msg,
+	}
+	t.add(e)
+	if e.ClientTag != "" {
+		t.pending[e.ClientTag] = e
+	}
+	return e
+}
+
+// receive completes the pending request for a message, or adds an entry for
+// an unsolicited message.
+func (t *Trace) receive(msg Message) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if e, ok := t.pending[msg.Header.ClientTag]; ok {
+		t.complete(e, &msg, nil)
+		return
+	}
+	e := &TraceEntry{
+		Time:           time.Now(),
+		ClientTag:      msg.Header.ClientTag,
+		CommuniqueType: msg.CommuniqueType,
+		Url:            msg.Header.Url,
+		Response:       &msg,
+	}
+	t.add(e)
+	t.complete(e, &msg, nil)
+}
+
+// finish completes an entry with an error, if the response was not already
+// received.
+func (t *Trace) finish(e *TraceEntry, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.complete(e, nil, err)
+}
+
+// complete records the outcome of an entry and notifies tails.
+//
+// The caller must hold t.lock.
+func (t *Trace) complete(e *TraceEntry, response *Message, err error) {
+	if e.Done {
+		return
+	}
+	e.Done = true
+	if t.pending[e.ClientTag] == e {
+		delete(t.pending, e.ClientTag)
+	}
+	if e.Request != nil {
+		e.Latency = time.Since(e.Time)
+	}
+	if response != nil {
+		e.Response = response
+		if leapErr := newLEAPError(*response); leapErr != nil {
+			err = leapErr
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	for ch := range t.tails {
+		select {
+		case ch <- *e:
+		default:
+		}
+	}
+}
+
+// add inserts an entry, evicting the oldest entry if the buffer is full.
+//
+// The caller must hold t.lock.
+func (t *Trace) add(e *TraceEntry) {
+	t.lastID++
+	e.ID = t.lastID
+	if len(t.entries) < cap(t.entries) {
+		t.entries = append(t.entries, e)
+		return
+	}
+	if cap(t.entries) == 0 {
+		return
+	}
+	old := t.entries[t.start]
+	if t.pending[old.ClientTag] == old {
+		delete(t.pending, old.ClientTag)
+	}
+	t.entries[t.start] = e
+	t.start = (t.start + 1) % len(t.entries)
+}
+
+type tracingConn struct {
+	BrokerConn
+	trace  *Trace
+	cancel context.CancelFunc
+}
+
+func (t *tracingConn) Send(msg Message) error {
+	e := t.trace.send(msg)
+	err := t.BrokerConn.Send(msg)
+	if err != nil {
+		t.trace.finish(e, err)
+	}
+	return err
+}
+
+func (t *tracingConn) Call(
+	ctx context.Context,
+	msg Message,
+	f func(Message) (bool, error),
+) (Message, error) {
+	e := t.trace.send(msg)
+	response, err := t.BrokerConn.Call(ctx, msg, f)
+	if err != nil {
+		t.trace.finish(e, err)
+	}
+	// Responses are recorded as they are received, since the same message
+	// is also delivered to our subscription.
+	return response, err
+}
+
+func (t *tracingConn) Close() error {
+	t.cancel()
+	return t.BrokerConn.Close()
+}
@@ -0,0 +1,124 @@
+package leap_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func TestTrace(t *testing.T) {
+	ctx := context.Background()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	trace := leap.NewTrace(3)
+	conn := trace.Wrap(broker)
+	defer conn.Close()
+
+	tailCtx, cancel := context.WithCancel(ctx)
+	tail := trace.Tail(tailCtx)
+
+	var result any
+	if err := leap.ReadRequest(ctx, conn, "/zone/1", &result); err != nil {
+		t.Fatal(err)
+	}
+	leap.ReadRequestWithRetry(ctx, conn, "/zone/99", &result, leap.NoRetry)
+
+	entries := waitForTrace(t, trace, 2)
+	ok, notFound := entries[0], entries[1]
+	if ok.Url != "/zone/1" || ok.Request == nil || ok.Response == nil || !ok.Done || ok.Error != "" {
+		t.Errorf("unexpected entry: %+v", ok)
+	}
+	if ok.Response.Header.ClientTag != ok.ClientTag || ok.Latency <= 0 {
+		t.Errorf("unexpected response or latency: %+v", ok)
+	}
+	if notFound.Error == "" || notFound.Response == nil {
+		t.Errorf("expected error for missing zone: %+v", notFound)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-tail:
+			if e.ID != entries[i].ID {
+				t.Errorf("unexpected tail entry %d: %+v", i, e)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for tail")
+		}
+	}
+	cancel()
+
+	// Requests without responses are traced with the error from the caller.
+	broker.DropResponses("/zone/2", 1)
+	timeoutCtx, cancelTimeout := context.WithTimeout(ctx, time.Millisecond*20)
+	defer cancelTimeout()
+	leap.ReadRequestWithRetry(timeoutCtx, conn, "/zone/2", &result, leap.NoRetry)
+
+	leap.AckCreateRequest(ctx, conn, "/button/10/commandprocessor", map[string]any{
+		"Command": map[string]any{"CommandType": "PressAndRelease"},
+	})
+
+	entries = waitForTrace(t, trace, 3)
+	if entries[0].Url != "/zone/99" {
+		t.Fatalf("expected oldest entries to be evicted: %+v", entries)
+	}
+	if entries[1].Url != "/zone/2" || entries[1].Response != nil || entries[1].Error == "" {
+		t.Errorf("unexpected entry for dropped response: %+v", entries[1])
+	}
+
+	filtered := trace.Entries(leap.TraceFilter{CommuniqueType: "CreateResponse"})
+	if len(filtered) != 1 || filtered[0].Url != "/button/10/commandprocessor" {
+		t.Errorf("unexpected filtered entries: %+v", filtered)
+	}
+	filtered = trace.Entries(leap.TraceFilter{UrlPrefix: "/zone/"})
+	if len(filtered) != 2 {
+		t.Errorf("unexpected filtered entries: %+v", filtered)
+	}
+}
+
+func TestTraceSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	defer broker.Close()
+
+	trace := leap.NewTrace(10)
+	conn := trace.Wrap(broker)
+	defer conn.Close()
+
+	updates, err := leap.SubscribeRequest[any](ctx, conn, "/zone/1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveUpdate(t, updates)
+	broker.SetZoneLevel("/zone/1", 10)
+	receiveUpdate(t, updates)
+
+	entries := waitForTrace(t, trace, 2)
+	if entries[0].Request == nil || entries[0].CommuniqueType != "SubscribeRequest" {
+		t.Errorf("unexpected subscribe entry: %+v", entries[0])
+	}
+	if entries[1].Request != nil || entries[1].ClientTag != entries[0].ClientTag {
+		t.Errorf("unexpected update entry: %+v", entries[1])
+	}
+}
+
+// waitForTrace waits until the trace has n entries which are all done.
+func waitForTrace(t *testing.T, trace *leap.Trace, n int) []leap.TraceEntry {
+	var entries []leap.TraceEntry
+	leaptest.WaitFor(t, func() bool {
+		entries = trace.Entries(leap.TraceFilter{})
+		if len(entries) != n {
+			return false
+		}
+		for _, e := range entries {
+			if !e.Done {
+				return false
+			}
+		}
+		return true
+	})
+	return entries
+}
@@ -0,0 +1,303 @@
+package leap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnsupportedControlType is returned by ZoneCommand for zones which it
+// does not know how to control.
+var ErrUnsupportedControlType = errors.New("unsupported zone control type")
+
+// lightControlTypes are the zone control types which count as lights, e.g.
+// for an area's OnCount or turning everything off.
+var lightControlTypes = map[string]bool{
+	"Dimmed":       true,
+	"Switched":     true,
+	"WhiteTune":    true,
+	"SpectrumTune": true,
+	"ColorTune":    true,
+}
+
+// IsLight checks if a zone control type is a light, as opposed to a shade,
+// fan or relay.
+func IsLight(controlType string) bool {
+	return lightControlTypes[controlType]
+}
+
+// fadeControlTypes are the zone control types which support fade times.
+// These, and switched zones, support delay times.
+var fadeControlTypes = map[string]bool{
+	"Dimmed":       true,
+	"WhiteTune":    true,
+	"SpectrumTune": true,
+	"ColorTune":    true,
+}
+
+// SupportsFade checks if commands for a zone control type can have a fade
+// time.
+func SupportsFade(controlType string) bool {
+	return fadeControlTypes[controlType]
+}
+
+// SupportsDelay checks if commands for a zone control type can have a delay
+// time.
+func SupportsDelay(controlType string) bool {
+	return fadeControlTypes[controlType] || controlType == "Switched"
+}
+
+// levelControlTypes are the zone control types which ZoneCommand supports.
+var levelControlTypes = map[string]bool{
+	"Dimmed":        true,
+	"Switched":      true,
+	"WhiteTune":     true,
+	"SpectrumTune":  true,
+	"ColorTune":     true,
+	"Shade":         true,
+	"ShadeWithTilt": true,
+	"FanSpeed":      true,
+	"CCO":           true,
+}
+
+// SupportsLevel checks if a zone control type can be moved to a level with
+// ZoneCommand.
+func SupportsLevel(controlType string) bool {
+	return levelControlTypes[controlType]
+}
+
+// SupportsTilt checks if a zone control type is a shade which can tilt.
+func SupportsTilt(controlType string) bool {
+	return controlType == "ShadeWithTilt" || controlType == "Tilt"
+}
+
+// TiltCommand builds the body of a CreateRequest which tilts a shade, from 0
+// to 100.
+func TiltCommand(controlType string, tilt int) (map[string]any, error) {
+	if tilt < 0 || tilt > 100 {
+		return nil, fmt.Errorf("tilt %d is out of range", tilt)
+	}
+	if !SupportsTilt(controlType) {
+		return nil, fmt.Errorf("%s zones do not support tilt", controlType)
+	}
+	return map[string]any{
+		"Command": map[string]any{
+			"CommandType":    "GoToTilt",
+			"TiltParameters": map[string]any{"Tilt": tilt},
+		},
+	}, nil
+}
+
+// ZoneTarget is the desired state of a zone.
+type ZoneTarget struct {
+	// Level is from 0 to 100. For switched zones and relays, any positive
+	// level turns the zone on, and for fans, each quarter of the range is a
+	// fan speed.
+	Level int
+
+	// Fade is how long the zone takes to reach the level, and Delay is how
+	// long to wait before starting. Zero uses the bridge's default.
+	Fade  time.Duration
+	Delay time.Duration
+}
+
+// ZoneCommand builds the body of a CreateRequest to a zone's command
+// processor which moves the zone to the target state.
+func ZoneCommand(controlType string, target ZoneTarget) (map[string]any, error) {
+	if target.Level < 0 || target.Level > 100 {
+		return nil, fmt.Errorf("level %d is out of range", target.Level)
+	}
+
+	var commandType, paramsKey string
+	params := map[string]any{}
+	switch controlType {
+	case "Dimmed":
+		commandType, paramsKey = "GoToDimmedLevel", "DimmedLevelParameters"
+		params["Level"] = target.Level
+	case "Switched":
+		commandType, paramsKey = "GoToSwitchedLevel", "SwitchedLevelParameters"
+		params["SwitchedLevel"] = switchedLevel(target.Level)
+	case "WhiteTune":
+		commandType, paramsKey = "GoToWhiteTuningLevel", "WhiteTuningLevelParameters"
+		params["Level"] = target.Level
+	case "SpectrumTune", "ColorTune":
+		commandType, paramsKey = "GoToSpectrumTuningLevel", "SpectrumTuningLevelParameters"
+		params["Level"] = target.Level
+	case "Shade", "ShadeWithTilt":
+		commandType, paramsKey = "GoToShadeLevel", "ShadeLevelParameters"
+		params["Level"] = target.Level
+	case "FanSpeed":
+		commandType, paramsKey = "GoToFanSpeed", "FanSpeedParameters"
+		params["FanSpeed"] = FanSpeedForLevel(target.Level)
+	case "CCO":
+		commandType, paramsKey = "GoToCCOLevel", "CCOLevelParameters"
+		// A closed contact is "on".
+		params["CCOLevel"] = "Open"
+		if target.Level > 0 {
+			params["CCOLevel"] = "Closed"
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedControlType, controlType)
+	}
+
+	if err := setCommandTimes(params, controlType, target.Fade, target.Delay); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"Command": map[string]any{
+			"CommandType": commandType,
+			paramsKey:     params,
+		},
+	}, nil
+}
+
+// DefaultKelvinRange is the range of color temperatures assumed for zones
+// which do not report their own.
+var DefaultKelvinRange = KelvinRange{Min: 1400, Max: 10000}
+
+// ColorTarget is the desired color of a tunable zone. Exactly one of Kelvin
+// and HSV must be set.
+type ColorTarget struct {
+	Kelvin int
+	HSV    *HSVTuningLevel
+
+	// Vibrancy, from 0 to 100, is only supported by SpectrumTune zones. If
+	// Level is nil, the brightness is left alone.
+	Vibrancy *int
+	Level    *int
+
+	Fade time.Duration
+}
+
+// ColorCommand builds the body of a CreateRequest which changes the color of
+// a tunable zone.
+//
+// If kelvinRange is nil, DefaultKelvinRange is used to check Kelvin.
+func ColorCommand(controlType string, kelvinRange *KelvinRange, target ColorTarget) (map[string]any, error) {
+	if (target.Kelvin != 0) == (target.HSV != nil) {
+		return nil, errors.New("expected exactly one of a color temperature or a hue and saturation")
+	}
+	if kelvinRange == nil {
+		kelvinRange = &DefaultKelvinRange
+	}
+	if target.Kelvin != 0 && (target.Kelvin < kelvinRange.Min || target.Kelvin > kelvinRange.Max) {
+		return nil, fmt.Errorf("color temperature %dK is outside of the supported range %d-%dK",
+			target.Kelvin, kelvinRange.Min, kelvinRange.Max)
+	}
+	if hsv := target.HSV; hsv != nil {
+		if hsv.Hue < 0 || hsv.Hue > 360 {
+			return nil, fmt.Errorf("hue %d is out of range", hsv.Hue)
+		}
+		if hsv.Saturation < 0 || hsv.Saturation > 100 {
+			return nil, fmt.Errorf("saturation %d is out of range", hsv.Saturation)
+		}
+	}
+	if target.Vibrancy != nil && (*target.Vibrancy < 0 || *target.Vibrancy > 100) {
+		return nil, fmt.Errorf("vibrancy %d is out of range", *target.Vibrancy)
+	}
+	if target.Level != nil && (*target.Level < 0 || *target.Level > 100) {
+		return nil, fmt.Errorf("level %d is out of range", *target.Level)
+	}
+
+	var commandType, paramsKey string
+	params := map[string]any{}
+	switch controlType {
+	case "WhiteTune":
+		if target.HSV != nil || target.Vibrancy != nil {
+			return nil, errors.New("WhiteTune zones only support color temperatures")
+		}
+		commandType, paramsKey = "GoToWhiteTuningLevel", "WhiteTuningLevelParameters"
+		params["WhiteTuningLevel"] = WhiteTuningLevel{Kelvin: target.Kelvin}
+	case "SpectrumTune", "ColorTune":
+		if target.Vibrancy != nil && controlType != "SpectrumTune" {
+			return nil, fmt.Errorf("%s zones do not support vibrancy", controlType)
+		}
+		commandType, paramsKey = "GoToSpectrumTuningLevel", "SpectrumTuningLevelParameters"
+		status := ColorTuningStatus{HSVTuningLevel: target.HSV}
+		if target.Kelvin != 0 {
+			status.WhiteTuningLevel = &WhiteTuningLevel{Kelvin: target.Kelvin}
+		}
+		params["ColorTuningStatus"] = status
+		if target.Vibrancy != nil {
+			params["Vibrancy"] = *target.Vibrancy
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q does not support color", ErrUnsupportedControlType, controlType)
+	}
+	if target.Level != nil {
+		params["Level"] = *target.Level
+	}
+
+	if err := setCommandTimes(params, controlType, target.Fade, 0); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"Command": map[string]any{
+			"CommandType": commandType,
+			paramsKey:     params,
+		},
+	}, nil
+}
+
+// setCommandTimes adds non-zero fade and delay times to command parameters,
+// checking that the zone supports them.
+func setCommandTimes(params map[string]any, controlType string, fade, delay time.Duration) error {
+	if fade != 0 {
+		if !SupportsFade(controlType) {
+			return fmt.Errorf("%s zones do not support fade times", controlType)
+		}
+		s, err := FormatDuration(fade)
+		if err != nil {
+			return fmt.Errorf("invalid fade: %w", err)
+		}
+		params["FadeTime"] = s
+	}
+	if delay != 0 {
+		if !SupportsDelay(controlType) {
+			return fmt.Errorf("%s zones do not support delay times", controlType)
+		}
+		s, err := FormatDuration(delay)
+		if err != nil {
+			return fmt.Errorf("invalid delay: %w", err)
+		}
+		params["DelayTime"] = s
+	}
+	return nil
+}
+
+func switchedLevel(level int) string {
+	if level > 0 {
+		return "On"
+	}
+	return "Off"
+}
+
+// FanSpeeds are the speeds of fan zones, from slowest to fastest.
+var FanSpeeds = []string{"Off", "Low", "Medium", "MediumHigh", "High"}
+
+// FanSpeedLevel returns the level of a fan speed, which is 0 for "Off" and
+// the top of the speed's quarter of the level range otherwise.
+func FanSpeedLevel(speed string) (int, bool) {
+	for i, s := range FanSpeeds {
+		if s == speed {
+			return i * 25, true
+		}
+	}
+	return 0, false
+}
+
+// FanSpeedForLevel maps each quarter of the level range to a fan speed.
+func FanSpeedForLevel(level int) string {
+	switch {
+	case level == 0:
+		return "Off"
+	case level <= 25:
+		return "Low"
+	case level <= 50:
+		return "Medium"
+	case level <= 75:
+		return "MediumHigh"
+	default:
+		return "High"
+	}
+}
@@ -0,0 +1,242 @@
+package leap_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+)
+
+func TestZoneCommand(t *testing.T) {
+	for _, c := range []struct {
+		controlType string
+		level       int
+		expected    string
+	}{
+		{"Dimmed", 40, `{"CommandType":"GoToDimmedLevel","DimmedLevelParameters":{"Level":40}}`},
+		{"Switched", 40, `{"CommandType":"GoToSwitchedLevel","SwitchedLevelParameters":{"SwitchedLevel":"On"}}`},
+		{"Switched", 0, `{"CommandType":"GoToSwitchedLevel","SwitchedLevelParameters":{"SwitchedLevel":"Off"}}`},
+		{"Shade", 70, `{"CommandType":"GoToShadeLevel","ShadeLevelParameters":{"Level":70}}`},
+		{"ShadeWithTilt", 0, `{"CommandType":"GoToShadeLevel","ShadeLevelParameters":{"Level":0}}`},
+		{"FanSpeed", 0, `{"CommandType":"GoToFanSpeed","FanSpeedParameters":{"FanSpeed":"Off"}}`},
+		{"FanSpeed", 60, `{"CommandType":"GoToFanSpeed","FanSpeedParameters":{"FanSpeed":"MediumHigh"}}`},
+		{"FanSpeed", 100, `{"CommandType":"GoToFanSpeed","FanSpeedParameters":{"FanSpeed":"High"}}`},
+		{"CCO", 100, `{"CCOLevelParameters":{"CCOLevel":"Closed"},"CommandType":"GoToCCOLevel"}`},
+		{"CCO", 0, `{"CCOLevelParameters":{"CCOLevel":"Open"},"CommandType":"GoToCCOLevel"}`},
+		{"SpectrumTune", 25, `{"CommandType":"GoToSpectrumTuningLevel","SpectrumTuningLevelParameters":{"Level":25}}`},
+	} {
+		body, err := leap.ZoneCommand(c.controlType, leap.ZoneTarget{Level: c.level})
+		if err != nil {
+			t.Errorf("%s: %v", c.controlType, err)
+			continue
+		}
+		data, _ := json.Marshal(body["Command"])
+		if string(data) != c.expected {
+			t.Errorf("%s at %d: expected %s but got %s", c.controlType, c.level, c.expected, data)
+		}
+	}
+
+	if _, err := leap.ZoneCommand("Receptacle", leap.ZoneTarget{}); !errors.Is(err, leap.ErrUnsupportedControlType) {
+		t.Errorf("expected unsupported control type error but got %v", err)
+	}
+	if _, err := leap.ZoneCommand("Dimmed", leap.ZoneTarget{Level: 101}); err == nil {
+		t.Error("expected error for out of range level")
+	}
+}
+
+func TestZoneCommandFadeAndDelay(t *testing.T) {
+	body, err := leap.ZoneCommand("Dimmed", leap.ZoneTarget{
+		Level: 50,
+		Fade:  time.Second * 90,
+		Delay: time.Second * 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(body["Command"])
+	expected := `{"CommandType":"GoToDimmedLevel","DimmedLevelParameters":` +
+		`{"DelayTime":"00:00:02","FadeTime":"00:01:30","Level":50}}`
+	if string(data) != expected {
+		t.Errorf("expected %s but got %s", expected, data)
+	}
+
+	if _, err := leap.ZoneCommand("Switched", leap.ZoneTarget{Delay: time.Second}); err != nil {
+		t.Errorf("unexpected error for switched delay: %v", err)
+	}
+	for _, c := range []struct {
+		controlType string
+		target      leap.ZoneTarget
+	}{
+		{"Switched", leap.ZoneTarget{Fade: time.Second}},
+		{"Shade", leap.ZoneTarget{Delay: time.Second}},
+		{"Dimmed", leap.ZoneTarget{Fade: time.Millisecond * 1500}},
+		{"Dimmed", leap.ZoneTarget{Delay: -time.Second}},
+		{"Dimmed", leap.ZoneTarget{Fade: leap.MaxDuration + time.Second}},
+	} {
+		if _, err := leap.ZoneCommand(c.controlType, c.target); err == nil {
+			t.Errorf("%s %+v: expected an error", c.controlType, c.target)
+		}
+	}
+}
+
+func TestTiltCommand(t *testing.T) {
+	body, err := leap.TiltCommand("ShadeWithTilt", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(body["Command"])
+	expected := `{"CommandType":"GoToTilt","TiltParameters":{"Tilt":30}}`
+	if string(data) != expected {
+		t.Errorf("expected %s but got %s", expected, data)
+	}
+	if _, err := leap.TiltCommand("Shade", 30); err == nil {
+		t.Error("expected error for shade without tilt")
+	}
+	if _, err := leap.TiltCommand("Tilt", 101); err == nil {
+		t.Error("expected error for out of range tilt")
+	}
+}
+
+func TestColorCommand(t *testing.T) {
+	level, vibrancy := 80, 40
+	for _, c := range []struct {
+		controlType string
+		target      leap.ColorTarget
+		expected    string
+	}{
+		{
+			"WhiteTune",
+			leap.ColorTarget{Kelvin: 2700, Fade: time.Second * 3},
+			`{"CommandType":"GoToWhiteTuningLevel","WhiteTuningLevelParameters":` +
+				`{"FadeTime":"00:00:03","WhiteTuningLevel":{"Kelvin":2700}}}`,
+		},
+		{
+			"SpectrumTune",
+			leap.ColorTarget{HSV: &leap.HSVTuningLevel{Hue: 240, Saturation: 100}, Vibrancy: &vibrancy},
+			`{"CommandType":"GoToSpectrumTuningLevel","SpectrumTuningLevelParameters":` +
+				`{"ColorTuningStatus":{"HSVTuningLevel":{"Hue":240,"Saturation":100}},"Vibrancy":40}}`,
+		},
+		{
+			"ColorTune",
+			leap.ColorTarget{Kelvin: 5000, Level: &level},
+			`{"CommandType":"GoToSpectrumTuningLevel","SpectrumTuningLevelParameters":` +
+				`{"ColorTuningStatus":{"WhiteTuningLevel":{"Kelvin":5000}},"Level":80}}`,
+		},
+	} {
+		body, err := leap.ColorCommand(c.controlType, nil, c.target)
+		if err != nil {
+			t.Errorf("%s: %v", c.controlType, err)
+			continue
+		}
+		data, _ := json.Marshal(body["Command"])
+		if string(data) != c.expected {
+			t.Errorf("%s: expected %s but got %s", c.controlType, c.expected, data)
+		}
+	}
+
+	kelvinRange := &leap.KelvinRange{Min: 1800, Max: 3000}
+	hsv := &leap.HSVTuningLevel{Hue: 10, Saturation: 10}
+	for _, c := range []struct {
+		controlType string
+		target      leap.ColorTarget
+	}{
+		{"WhiteTune", leap.ColorTarget{Kelvin: 4000}},
+		{"WhiteTune", leap.ColorTarget{HSV: hsv}},
+		{"WhiteTune", leap.ColorTarget{}},
+		{"SpectrumTune", leap.ColorTarget{Kelvin: 2700, HSV: hsv}},
+		{"SpectrumTune", leap.ColorTarget{HSV: &leap.HSVTuningLevel{Hue: 361}}},
+		{"SpectrumTune", leap.ColorTarget{HSV: &leap.HSVTuningLevel{Saturation: -1}}},
+		{"ColorTune", leap.ColorTarget{HSV: hsv, Vibrancy: &vibrancy}},
+		{"Dimmed", leap.ColorTarget{Kelvin: 2700}},
+	} {
+		if _, err := leap.ColorCommand(c.controlType, kelvinRange, c.target); err == nil {
+			t.Errorf("%s %+v: expected an error", c.controlType, c.target)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for _, c := range []struct {
+		d time.Duration
+		s string
+	}{
+		{0, "00:00:00"},
+		{time.Second * 5, "00:00:05"},
+		{time.Minute*61 + time.Second*1, "01:01:01"},
+		{leap.MaxDuration, "04:00:00"},
+	} {
+		s, err := leap.FormatDuration(c.d)
+		if err != nil || s != c.s {
+			t.Errorf("format %v: expected %s but got %s (err=%v)", c.d, c.s, s, err)
+		}
+		d, err := leap.ParseDuration(c.s)
+		if err != nil || d != c.d {
+			t.Errorf("parse %s: expected %v but got %v (err=%v)", c.s, c.d, d, err)
+		}
+	}
+	for _, s := range []string{"", "5", "00:60:00", "0:00:01", "00:00:01.5"} {
+		if _, err := leap.ParseDuration(s); err == nil {
+			t.Errorf("parse %q: expected an error", s)
+		}
+	}
+}
+
+func TestHVACCommands(t *testing.T) {
+	heat, cool := 20.04, 25.0
+	body, err := leap.SetPointCommand(&heat, &cool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(body["Command"])
+	expected := `{"CommandType":"GoToHVACSetPoints","HVACSetPointsParameters":{"CoolSetPoint":25,"HeatSetPoint":20}}`
+	if string(data) != expected {
+		t.Errorf("expected %s but got %s", expected, data)
+	}
+	if _, err := leap.SetPointCommand(nil, nil); err == nil {
+		t.Error("expected error without setpoints")
+	}
+	if _, err := leap.SetPointCommand(&cool, &heat); err == nil {
+		t.Error("expected error for heat above cool")
+	}
+	tooHot := 40.0
+	if _, err := leap.SetPointCommand(nil, &tooHot); err == nil {
+		t.Error("expected error for out of range setpoint")
+	}
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := leap.SetPointCommand(&value, nil); err == nil {
+			t.Errorf("expected error for setpoint %v", value)
+		}
+	}
+
+	body, err = leap.HVACModeCommand("Cool", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = json.Marshal(body["Command"])
+	expected = `{"CommandType":"GoToHVACMode","HVACModeParameters":{"OperatingMode":"Cool"}}`
+	if string(data) != expected {
+		t.Errorf("expected %s but got %s", expected, data)
+	}
+	if _, err := leap.HVACModeCommand("Warm", ""); err == nil {
+		t.Error("expected error for unknown operating mode")
+	}
+	if _, err := leap.HVACModeCommand("", "Fast"); err == nil {
+		t.Error("expected error for unknown fan mode")
+	}
+}
+
+func TestTemperature(t *testing.T) {
+	if f := leap.CelsiusToFahrenheit(100); f != 212 {
+		t.Errorf("unexpected boiling point: %f", f)
+	}
+	if c := leap.FahrenheitToCelsius(-40); c != -40 {
+		t.Errorf("unexpected conversion of -40: %f", c)
+	}
+	temp := leap.NewTemperature(leap.FahrenheitToCelsius(70))
+	if temp.Celsius != 21.1 || temp.Fahrenheit != 70 {
+		t.Errorf("unexpected temperature: %+v", temp)
+	}
+}
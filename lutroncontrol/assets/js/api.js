@@ -46,6 +46,35 @@ function deviceName(device) {
     }
     return device.FullyQualifiedName[device.FullyQualifiedName.length - 1];
 }
+function primaryZone(device) {
+    return (device.Zones || []).find((z) => z.Href === device.Zone);
+}
+function deviceControlType(device) {
+    const zone = primaryZone(device);
+    if (zone && zone.ControlType) {
+        return zone.ControlType;
+    }
+    if (device.DeviceType === 'QsWirelessShade') {
+        return "Shade";
+    }
+    else if (device.DeviceType === 'WallSwitch') {
+        return "Switched";
+    }
+    return "Dimmed";
+}
+function levelCommandType(device) {
+    const zone = primaryZone(device);
+    if (zone && zone.ControlType) {
+        return "";
+    }
+    return deviceControlType(device) === "Switched" ? "GoToSwitchedLevel" : "GoToDimmedLevel";
+}
+function isShadeControlType(controlType) {
+    return controlType === "Shade" || controlType === "ShadeWithTilt";
+}
+function isSwitchControlType(controlType) {
+    return controlType === "Switched" || controlType === "CCO";
+}
 function hrefId(href) {
     if (!href) {
         return null;
@@ -62,9 +91,11 @@ function setLevel(zoneHref, level, commandType) {
         if (!zoneId) {
             throw new RemoteError("invalid zone reference");
         }
-        const url = `command/set_level?type=${encodeURIComponent(commandType)}` +
-            `&zone=${encodeURIComponent(zoneId)}` +
+        let url = `command/set_level?zone=${encodeURIComponent(zoneId)}` +
             `&level=${encodeURIComponent(level.toString())}`;
+        if (commandType) {
+            url += `&type=${encodeURIComponent(commandType)}`;
+        }
         return fetchAPI(url);
     });
 }
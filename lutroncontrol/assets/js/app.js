@@ -218,11 +218,12 @@ class DeviceView extends View {
         const controls = document.createElement('div');
         controls.className = 'device-controls';
         this.element.appendChild(controls);
-        if (device.Zone && device.DeviceType === 'QsWirelessShade') {
+        const controlType = deviceControlType(device);
+        if (device.Zone && isShadeControlType(controlType)) {
             this.buildShadeControls(controls, device);
         }
-        else if (device.Zone) {
-            if (device.Level !== undefined) {
+        else if (device.Zone && controlType !== "HVAC") {
+            if (device.Level !== undefined && !isSwitchControlType(controlType)) {
                 this.buildDimmerControls(controls, device);
             }
             else {
@@ -235,7 +236,6 @@ class DeviceView extends View {
     }
     buildDimmerControls(controls, device) {
         var _a, _b;
-        const commandType = this.levelCommandType(device);
         const row = document.createElement('div');
         row.className = 'control-row';
         const sliderWrap = document.createElement('div');
@@ -253,7 +253,7 @@ class DeviceView extends View {
         });
         this.slider.addEventListener('change', () => {
             const value = parseInt(this.slider.value, 10);
-            this.applyLevel(device.Zone, value, commandType);
+            this.applyLevel(device.Zone, value);
         });
         sliderWrap.appendChild(this.slider);
         this.levelValue = document.createElement('div');
@@ -263,8 +263,8 @@ class DeviceView extends View {
         row.appendChild(sliderWrap);
         const buttonRow = document.createElement('div');
         buttonRow.className = 'button-row';
-        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0, commandType));
-        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100, commandType));
+        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0));
+        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100));
         buttonRow.appendChild(offButton);
         buttonRow.appendChild(onButton);
         row.appendChild(buttonRow);
@@ -273,8 +273,8 @@ class DeviceView extends View {
     buildSwitchControls(controls, device) {
         const buttonRow = document.createElement('div');
         buttonRow.className = 'button-row';
-        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0, 'GoToSwitchedLevel'));
-        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100, 'GoToSwitchedLevel'));
+        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0));
+        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100));
         buttonRow.appendChild(offButton);
         buttonRow.appendChild(onButton);
         controls.appendChild(buttonRow);
@@ -306,15 +306,6 @@ class DeviceView extends View {
         buttonRow.appendChild(downButton);
         controls.appendChild(buttonRow);
     }
-    levelCommandType(device) {
-        if (device.DeviceType === 'WallSwitch') {
-            return 'GoToSwitchedLevel';
-        }
-        if (device.DeviceType === 'WallDimmer') {
-            return 'GoToDimmedLevel';
-        }
-        return 'GoToDimmedLevel';
-    }
     buildButtonControls(buttons) {
         const section = document.createElement('div');
         section.className = 'button-section';
@@ -358,14 +349,14 @@ class DeviceView extends View {
             }
         });
     }
-    applyLevel(zoneHref, level, commandType) {
+    applyLevel(zoneHref, level) {
         return __awaiter(this, void 0, void 0, function* () {
             if (this.busy) {
                 return;
             }
             this.setBusy(true);
             try {
-                yield setLevel(zoneHref, level, commandType);
+                yield setLevel(zoneHref, level, levelCommandType(this.device));
                 if (this.slider) {
                     this.slider.value = level.toString();
                 }
@@ -13,11 +13,58 @@ class RemoteError extends Error {
 }
 
 interface LutronDevice {
+    Href: string;
+    Name: string;
     FullyQualifiedName: NonNullable<string[]>;
     DeviceType: NonNullable<LutronDeviceType>;
+    Area?: string;
+    SerialNumber?: number;
+    ModelNumber?: string;
+    Firmware?: string;
+    AddressedState?: string;
+    Occupancy?: string;
     Level?: number;
     Zone?: string;
+    Zones?: ZoneInfo[];
+    FanSpeed?: string;
+    Battery?: string;
+    Availability?: string;
+    LastSeen?: string;
     Buttons?: ButtonInfo[];
+    ButtonGroups?: string[];
+}
+
+interface ZoneInfo {
+    Href: string;
+    Name: string;
+    ControlType: string;
+    Level?: number;
+    StatusAccuracy?: string;
+    FanSpeed?: string;
+    Tilt?: number;
+    Color?: ColorTuningStatus;
+    Vibrancy?: number;
+    KelvinRange?: { Min: number, Max: number };
+    HVAC?: HVACInfo;
+}
+
+interface Temperature {
+    Celsius: number;
+    Fahrenheit: number;
+}
+
+interface HVACInfo {
+    CurrentTemperature?: Temperature;
+    HeatSetPoint?: Temperature;
+    CoolSetPoint?: Temperature;
+    OperatingMode?: string;
+    FanMode?: string;
+    ScheduleStatus?: string;
+}
+
+interface ColorTuningStatus {
+    WhiteTuningLevel?: { Kelvin: number };
+    HSVTuningLevel?: { Hue: number, Saturation: number };
 }
 
 interface ButtonInfo {
@@ -93,6 +140,43 @@ function deviceName(device: LutronDevice): string {
     return device.FullyQualifiedName[device.FullyQualifiedName.length - 1];
 }
 
+function primaryZone(device: LutronDevice): ZoneInfo | undefined {
+    return (device.Zones || []).find((z) => z.Href === device.Zone);
+}
+
+function deviceControlType(device: LutronDevice): string {
+    const zone = primaryZone(device);
+    if (zone && zone.ControlType) {
+        return zone.ControlType;
+    }
+    // Bridges without a zone list don't report control types, so guess from
+    // the device type instead.
+    if (device.DeviceType === 'QsWirelessShade') {
+        return "Shade";
+    } else if (device.DeviceType === 'WallSwitch') {
+        return "Switched";
+    }
+    return "Dimmed";
+}
+
+// levelCommandType returns the set_level command type for a device, or an
+// empty string if the server can choose it from the zone's control type.
+function levelCommandType(device: LutronDevice): string {
+    const zone = primaryZone(device);
+    if (zone && zone.ControlType) {
+        return "";
+    }
+    return deviceControlType(device) === "Switched" ? "GoToSwitchedLevel" : "GoToDimmedLevel";
+}
+
+function isShadeControlType(controlType: string): boolean {
+    return controlType === "Shade" || controlType === "ShadeWithTilt";
+}
+
+function isSwitchControlType(controlType: string): boolean {
+    return controlType === "Switched" || controlType === "CCO";
+}
+
 function hrefId(href?: string): string | null {
     if (!href) {
         return null;
@@ -109,9 +193,13 @@ async function setLevel(zoneHref: string, level: number, commandType: string): P
     if (!zoneId) {
         throw new RemoteError("invalid zone reference");
     }
-    const url = `command/set_level?type=${encodeURIComponent(commandType)}` +
-        `&zone=${encodeURIComponent(zoneId)}` +
+    // Without a command type, the server picks the command for the zone's
+    // control type.
+    let url = `command/set_level?zone=${encodeURIComponent(zoneId)}` +
         `&level=${encodeURIComponent(level.toString())}`;
+    if (commandType) {
+        url += `&type=${encodeURIComponent(commandType)}`;
+    }
     return fetchAPI<boolean>(url);
 }
 
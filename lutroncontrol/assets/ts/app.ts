@@ -263,10 +263,11 @@ class DeviceView extends View {
         controls.className = 'device-controls';
         this.element.appendChild(controls);
 
-        if (device.Zone && device.DeviceType === 'QsWirelessShade') {
+        const controlType = deviceControlType(device);
+        if (device.Zone && isShadeControlType(controlType)) {
             this.buildShadeControls(controls, device);
-        } else if (device.Zone) {
-            if (device.Level !== undefined) {
+        } else if (device.Zone && controlType !== "HVAC") {
+            if (device.Level !== undefined && !isSwitchControlType(controlType)) {
                 this.buildDimmerControls(controls, device);
             } else {
                 this.buildSwitchControls(controls, device);
@@ -279,7 +280,6 @@ class DeviceView extends View {
     }
 
     private buildDimmerControls(controls: HTMLElement, device: LutronDevice) {
-        const commandType = this.levelCommandType(device);
         const row = document.createElement('div');
         row.className = 'control-row';
 
@@ -299,7 +299,7 @@ class DeviceView extends View {
         });
         this.slider.addEventListener('change', () => {
             const value = parseInt(this.slider.value, 10);
-            this.applyLevel(device.Zone, value, commandType);
+            this.applyLevel(device.Zone, value);
         });
         sliderWrap.appendChild(this.slider);
 
@@ -313,8 +313,8 @@ class DeviceView extends View {
         const buttonRow = document.createElement('div');
         buttonRow.className = 'button-row';
 
-        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0, commandType));
-        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100, commandType));
+        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0));
+        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100));
 
         buttonRow.appendChild(offButton);
         buttonRow.appendChild(onButton);
@@ -326,8 +326,8 @@ class DeviceView extends View {
     private buildSwitchControls(controls: HTMLElement, device: LutronDevice) {
         const buttonRow = document.createElement('div');
         buttonRow.className = 'button-row';
-        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0, 'GoToSwitchedLevel'));
-        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100, 'GoToSwitchedLevel'));
+        const offButton = this.buildActionButton('Off', () => this.applyLevel(device.Zone, 0));
+        const onButton = this.buildActionButton('On', () => this.applyLevel(device.Zone, 100));
         buttonRow.appendChild(offButton);
         buttonRow.appendChild(onButton);
         controls.appendChild(buttonRow);
@@ -361,16 +361,6 @@ class DeviceView extends View {
         controls.appendChild(buttonRow);
     }
 
-    private levelCommandType(device: LutronDevice): string {
-        if (device.DeviceType === 'WallSwitch') {
-            return 'GoToSwitchedLevel';
-        }
-        if (device.DeviceType === 'WallDimmer') {
-            return 'GoToDimmedLevel';
-        }
-        return 'GoToDimmedLevel';
-    }
-
     private buildButtonControls(buttons: ButtonInfo[]) {
         const section = document.createElement('div');
         section.className = 'button-section';
@@ -415,13 +405,13 @@ class DeviceView extends View {
         }
     }
 
-    private async applyLevel(zoneHref: string, level: number, commandType: string) {
+    private async applyLevel(zoneHref: string, level: number) {
         if (this.busy) {
             return;
         }
         this.setBusy(true);
         try {
-            await setLevel(zoneHref, level, commandType);
+            await setLevel(zoneHref, level, levelCommandType(this.device));
             if (this.slider) {
                 this.slider.value = level.toString();
             }
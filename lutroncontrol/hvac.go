@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+)
+
+func (s *Server) serveSetHVAC(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		zone := r.FormValue("zone")
+		if _, err := strconv.Atoi(zone); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid zone: %w", err)
+		}
+		fahrenheit, err := fahrenheitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		heat, err := temperatureParam(r, "heat", fahrenheit)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		cool, err := temperatureParam(r, "cool", fahrenheit)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		mode, fan := r.FormValue("mode"), r.FormValue("fan")
+		if heat == nil && cool == nil && mode == "" && fan == "" {
+			return nil, http.StatusBadRequest, errors.New("expected heat, cool, mode, or fan")
+		}
+
+		zoneHref := "/zone/" + zone
+		info, err := s.zoneInfo(r.Context(), client, zoneHref)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if info.ControlType != "HVAC" {
+			return nil, http.StatusBadRequest, fmt.Errorf("%s is not an HVAC zone", zoneHref)
+		}
+		if err := checkSetPoints(info.HVAC, heat, cool); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		// Commands only include the changed settings, so they are never
+		// collapsed, and the zone's queue keeps them in order with level commands.
+		var commands []queuedCommand
+		if heat != nil || cool != nil {
+			body, err := leap.SetPointCommand(heat, cool)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			commands = append(commands, queuedCommand{
+				Url: zoneHref + "/commandprocessor", Body: body, Idempotent: true,
+				Description: "change setpoints",
+			})
+		}
+		if mode != "" || fan != "" {
+			body, err := leap.HVACModeCommand(mode, fan)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			commands = append(commands, queuedCommand{
+				Url: zoneHref + "/commandprocessor", Body: body, Idempotent: true,
+				Description: "change mode",
+			})
+		}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, commands...); err != nil {
+			return false, http.StatusInternalServerError, err
+		}
+		return true, http.StatusOK, nil
+	})
+}
+
+// checkSetPoints makes sure that the heat setpoint stays below the cool
+// setpoint, using the zone's current setpoints for the ones not being changed.
+func checkSetPoints(current *leap.HVACInfo, heat, cool *float64) error {
+	if current != nil {
+		if heat == nil && cool != nil && current.HeatSetPoint != nil {
+			heat = &current.HeatSetPoint.Celsius
+		} else if cool == nil && heat != nil && current.CoolSetPoint != nil {
+			cool = &current.CoolSetPoint.Celsius
+		}
+	}
+	if heat != nil && cool != nil && *heat >= *cool {
+		return fmt.Errorf("heat setpoint %.1f°C must be below cool setpoint %.1f°C", *heat, *cool)
+	}
+	return nil
+}
+
+// fahrenheitParam parses the optional "unit" argument, which is "C" (the
+// default) or "F".
+func fahrenheitParam(r *http.Request) (bool, error) {
+	switch unit := r.FormValue("unit"); unit {
+	case "", "C":
+		return false, nil
+	case "F":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown unit: %s", unit)
+	}
+}
+
+// temperatureParam parses an optional temperature argument, converting it to
+// Celsius.
+func temperatureParam(r *http.Request, name string, fahrenheit bool) (*float64, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	} else if math.IsNaN(result) || math.IsInf(result, 0) {
+		return nil, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	if fahrenheit {
+		result = leap.FahrenheitToCelsius(result)
+	}
+	return &result, nil
+}
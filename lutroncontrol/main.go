@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"log"
 	"os"
+	"time"
 
 	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/lutroncontrol/leap"
 )
 
 func main() {
@@ -12,23 +16,110 @@ func main() {
 	var savePath string
 	var addr string
 	var secret string
+	var recordPath string
+	var replayPath string
+	var transport string
+	var bridgeAddr string
+	var pair bool
+	var pairingCert string
+	var pairingKey string
+	var pairingCA string
+	var traceSize int
+	var adminToken string
+	var adminWrites bool
+	var commandInterval time.Duration
+	var shadeConfigPath string
 	flag.StringVar(&assetDir, "asset-dir", "assets", "path to asset directory")
 	flag.StringVar(&savePath, "save-path", "state.json", "path to save server state")
 	flag.StringVar(&addr, "addr", ":8080", "address to listen on")
 	flag.StringVar(&secret, "secret", "", "secret URL prefix (e.g. somesecret)")
+	flag.StringVar(&recordPath, "record", "", "append all broker traffic to this JSONL file")
+	flag.StringVar(&replayPath, "replay", "", "serve broker responses from this recording instead of connecting")
+	flag.StringVar(&transport, "transport", "cloud", "how to reach the bridge: 'cloud' or 'local'")
+	flag.StringVar(&bridgeAddr, "bridge", "", "bridge address on the local network, for -transport local")
+	flag.BoolVar(&pair, "pair", false, "pair with the local bridge before serving")
+	flag.StringVar(&pairingCert, "pairing-cert", "", "path to the Lutron pairing certificate (PEM)")
+	flag.StringVar(&pairingKey, "pairing-key", "", "path to the Lutron pairing private key (PEM)")
+	flag.StringVar(&pairingCA, "pairing-ca", "", "path to the Lutron pairing root certificate (PEM)")
+	flag.IntVar(&traceSize, "trace-size", 200, "number of recent broker messages to keep for /debug/leap (0 to disable)")
+	flag.StringVar(&adminToken, "admin-token", "", "token required by admin endpoints such as /debug/leap (empty to disable them)")
+	flag.BoolVar(&adminWrites, "admin-writes", false, "allow /admin/leap to send create and update requests to the bridge")
+	flag.StringVar(&shadeConfigPath, "shade-config", "", "path to a JSON file with named shade positions and shade groups")
+	flag.DurationVar(&commandInterval, "command-interval", CommandInterval, "minimum time between commands sent to the bridge (0 for no limit)")
+	flag.IntVar(&leap.DefaultBatchOptions.MaxParallel, "max-parallel-reads", leap.DefaultBatchOptions.MaxParallel,
+		"maximum concurrent broker reads per batch (0 for no limit)")
+	flag.IntVar(&leap.DefaultRetryPolicy.MaxAttempts, "retry-attempts", leap.DefaultRetryPolicy.MaxAttempts,
+		"maximum attempts for broker reads and idempotent commands")
+	flag.DurationVar(&leap.DefaultRetryPolicy.InitialBackoff, "retry-backoff", leap.DefaultRetryPolicy.InitialBackoff,
+		"delay before the first retry, doubled for each retry")
+	flag.DurationVar(&leap.DefaultRetryPolicy.MaxBackoff, "retry-max-backoff", leap.DefaultRetryPolicy.MaxBackoff,
+		"maximum delay between retries")
+	flag.DurationVar(&leap.DefaultRetryPolicy.AttemptTimeout, "retry-attempt-timeout", leap.DefaultRetryPolicy.AttemptTimeout,
+		"timeout for each broker read attempt")
 	flag.Parse()
 
 	if _, err := os.Stat(assetDir); os.IsNotExist(err) {
 		essentials.Die("The -asset-dir does not exist; pass an -asset-dir argument.")
 	}
 
+	if transport != "cloud" && transport != "local" {
+		essentials.Die("The -transport must be 'cloud' or 'local'.")
+	}
+	if transport == "local" && bridgeAddr == "" {
+		essentials.Die("Must specify -bridge for -transport local")
+	}
+
 	username := os.Getenv("LUTRON_USERNAME")
 	password := os.Getenv("LUTRON_PASSWORD")
-	if username == "" || password == "" {
+	if replayPath == "" && transport == "cloud" && (username == "" || password == "") {
 		essentials.Die("Must specify LUTRON_USERNAME and LUTRON_PASSWORD env vars")
 	}
 
 	server, err := NewServer(assetDir, savePath, username, password, secret)
 	essentials.Must(err)
+
+	if transport == "local" {
+		if pair {
+			if pairingCert == "" || pairingKey == "" || pairingCA == "" {
+				essentials.Die("Must specify -pairing-cert, -pairing-key, and -pairing-ca to pair")
+			}
+			pairingCreds, err := leap.LoadLocalCredentials(pairingCert, pairingKey, pairingCA)
+			essentials.Must(err)
+			log.Println("press the button on the back of the bridge to pair")
+			ctx, cancel := context.WithTimeout(context.Background(), PairingTimeout)
+			err = server.PairLocalBridge(ctx, bridgeAddr, pairingCreds)
+			cancel()
+			essentials.Must(err)
+			log.Println("paired with bridge")
+		}
+		server.UseLocalBridge(bridgeAddr)
+	}
+
+	if replayPath != "" {
+		records, err := leap.LoadRecording(replayPath)
+		essentials.Must(err)
+		server.SetConnector(func(ctx context.Context) (leap.BrokerConn, error) {
+			return leap.NewReplayConn(records), nil
+		})
+	}
+	if traceSize > 0 {
+		server.SetTrace(leap.NewTrace(traceSize))
+	}
+	server.SetCommandInterval(commandInterval)
+	server.SetAdminToken(adminToken)
+	server.SetAdminWrites(adminWrites)
+	if shadeConfigPath != "" {
+		shadeConfig, err := LoadShadeConfig(shadeConfigPath)
+		essentials.Must(err)
+		server.SetShadeConfig(shadeConfig)
+	}
+	if recordPath != "" {
+		recorder, err := leap.NewRecorder(recordPath)
+		essentials.Must(err)
+		// Serve() never returns normally, so the recorder is never closed;
+		// every record is flushed as it is written instead.
+		server.SetRecorder(recorder)
+	}
+
 	essentials.Must(server.Serve(addr))
 }
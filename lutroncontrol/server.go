@@ -2,17 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/unixpickle/lutronbroker/lutronbroker"
+	"github.com/unixpickle/lutroncontrol/leap"
 )
 
 const (
@@ -20,10 +25,31 @@ const (
 	ConnectionTimeout = time.Second * 10
 	PingInterval      = time.Second * 20
 	PingTimeout       = time.Second * 5
+	CommandTimeout    = time.Second * 5
+	CommandInterval   = time.Millisecond * 50
+	DevicesTimeout    = time.Minute
+	PairingTimeout    = time.Minute * 5
+	LocalClientName   = "lutroncontrol"
+	MaxAdminBodySize  = 1 << 20
 )
 
+// commandControlTypes maps the level command types accepted by set_level to
+// the zone control types which they are meant for.
+var commandControlTypes = map[string]string{
+	"GoToDimmedLevel":   "Dimmed",
+	"GoToSwitchedLevel": "Switched",
+	"GoToFanSpeed":      "FanSpeed",
+}
+
+// leapURLPattern matches the resource paths accepted by /admin/leap, such as
+// "/zone/1/status".
+var leapURLPattern = regexp.MustCompile(`^(/[A-Za-z0-9_-]+)+$`)
+
 type Server struct {
 	state    *ServerState
+	live     *leap.LiveState
+	devices  leap.Coalescer[[]*leap.DeviceInfo]
+	commands *leap.CommandQueue
 	assetDir string
 	savePath string
 	username string
@@ -31,9 +57,18 @@ type Server struct {
 	basePath string
 
 	sessionLock   sync.RWMutex
-	connection    BrokerConn
+	connection    leap.BrokerConn
 	reconnErr     error
 	reconnErrTime *time.Time
+
+	connector func(ctx context.Context) (leap.BrokerConn, error)
+	recorder  *leap.Recorder
+	trace     *leap.Trace
+
+	adminToken  string
+	adminWrites bool
+
+	shades *ShadeConfig
 }
 
 func NewServer(assetDir, savePath string, username string, password string, basePath string) (*Server, error) {
@@ -50,14 +85,92 @@ func NewServer(assetDir, savePath string, username string, password string, base
 	if len(basePath) > 1 && basePath[len(basePath)-1] == '/' {
 		basePath = basePath[:len(basePath)-1]
 	}
-	return &Server{
+	live := leap.NewLiveState()
+	live.Logger = log.Default()
+	s := &Server{
 		state:    state,
+		live:     live,
+		commands: leap.NewCommandQueue(CommandInterval),
 		assetDir: assetDir,
 		savePath: savePath,
 		username: username,
 		password: password,
 		basePath: basePath,
-	}, nil
+	}
+	s.connector = s.connectCloud
+	return s, nil
+}
+
+// SetConnector overrides how the server establishes new broker connections.
+// By default, the server connects to the Lutron cloud broker.
+func (s *Server) SetConnector(f func(ctx context.Context) (leap.BrokerConn, error)) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	s.connector = f
+}
+
+// UseLocalBridge causes future connections to go directly to the bridge at
+// addr over the local network, using credentials from PairLocalBridge.
+func (s *Server) UseLocalBridge(addr string) {
+	s.SetConnector(func(ctx context.Context) (leap.BrokerConn, error) {
+		return s.connectLocal(ctx, addr)
+	})
+}
+
+// PairLocalBridge obtains credentials from the bridge at addr and saves them
+// for UseLocalBridge.
+//
+// This blocks until somebody presses the button on the back of the bridge.
+func (s *Server) PairLocalBridge(ctx context.Context, addr string, pairing *leap.LocalCredentials) error {
+	creds, err := leap.PairLocal(ctx, addr, pairing, LocalClientName)
+	if err != nil {
+		return err
+	}
+	s.state.SetLocalCreds(creds)
+	return s.state.Save(s.savePath)
+}
+
+// SetRecorder causes all future broker connections to be recorded.
+func (s *Server) SetRecorder(r *leap.Recorder) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	s.recorder = r
+}
+
+// SetTrace causes all future broker connections to be traced, making the
+// trace available on the /debug/leap endpoint.
+func (s *Server) SetTrace(t *leap.Trace) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	s.trace = t
+}
+
+// getTrace returns the trace passed to SetTrace, or nil if tracing is
+// disabled.
+func (s *Server) getTrace() *leap.Trace {
+	s.sessionLock.RLock()
+	defer s.sessionLock.RUnlock()
+	return s.trace
+}
+
+// SetCommandInterval sets the minimum time between commands sent to the
+// bridge, replacing the default of CommandInterval.
+//
+// This should be called before the server starts handling requests.
+func (s *Server) SetCommandInterval(interval time.Duration) {
+	s.commands = leap.NewCommandQueue(interval)
+}
+
+// SetAdminToken enables the admin endpoints, which require clients to
+// present this token.
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+// SetAdminWrites allows the /admin/leap endpoint to send CreateRequests and
+// UpdateRequests, rather than only ReadRequests.
+func (s *Server) SetAdminWrites(allow bool) {
+	s.adminWrites = allow
 }
 
 func (s *Server) Serve(host string) error {
@@ -72,20 +185,44 @@ func (s *Server) addRoutes() *http.ServeMux {
 	if s.basePath == "/" {
 		mux.Handle("/", fs)
 		mux.HandleFunc("/devices", s.serveDevices)
+		mux.HandleFunc("/devices/{id}", s.serveDevice)
+		mux.HandleFunc("/health/devices", s.serveDeviceHealth)
+		mux.HandleFunc("/areas", s.serveAreas)
+		mux.HandleFunc("/occupancy", s.serveOccupancy)
 		mux.HandleFunc("/clear_cache", s.serveClearCache)
+		mux.HandleFunc("/stats", s.serveStats)
+		mux.HandleFunc("/debug/leap", s.serveDebugLEAP)
+		mux.HandleFunc("/admin/leap", s.serveAdminLEAP)
 		mux.HandleFunc("/command/all_off", s.serveAllOff)
 		mux.HandleFunc("/command/set_level", s.serveSetLevel)
+		mux.HandleFunc("/command/set_color", s.serveSetColor)
+		mux.HandleFunc("/command/set_hvac", s.serveSetHVAC)
 		mux.HandleFunc("/command/press_and_release", s.servePressAndRelease)
+		mux.HandleFunc("/command/set_tilt", s.serveSetTilt)
+		mux.HandleFunc("/command/shade_group", s.serveShadeGroup)
+		mux.HandleFunc("/shades", s.serveShades)
 		mux.HandleFunc("/scenes", s.serveScenes)
 		mux.HandleFunc("/scene/activate", s.serveSceneActivate)
 		mux.HandleFunc("/scene/activate_by_name", s.serveSceneActivateByName)
 	} else {
 		mux.Handle(s.basePath+"/", http.StripPrefix(s.basePath+"/", fs))
 		mux.HandleFunc(s.basePath+"/devices", s.serveDevices)
+		mux.HandleFunc(s.basePath+"/devices/{id}", s.serveDevice)
+		mux.HandleFunc(s.basePath+"/health/devices", s.serveDeviceHealth)
+		mux.HandleFunc(s.basePath+"/areas", s.serveAreas)
+		mux.HandleFunc(s.basePath+"/occupancy", s.serveOccupancy)
 		mux.HandleFunc(s.basePath+"/clear_cache", s.serveClearCache)
+		mux.HandleFunc(s.basePath+"/stats", s.serveStats)
+		mux.HandleFunc(s.basePath+"/debug/leap", s.serveDebugLEAP)
+		mux.HandleFunc(s.basePath+"/admin/leap", s.serveAdminLEAP)
 		mux.HandleFunc(s.basePath+"/command/all_off", s.serveAllOff)
 		mux.HandleFunc(s.basePath+"/command/set_level", s.serveSetLevel)
+		mux.HandleFunc(s.basePath+"/command/set_color", s.serveSetColor)
+		mux.HandleFunc(s.basePath+"/command/set_hvac", s.serveSetHVAC)
 		mux.HandleFunc(s.basePath+"/command/press_and_release", s.servePressAndRelease)
+		mux.HandleFunc(s.basePath+"/command/set_tilt", s.serveSetTilt)
+		mux.HandleFunc(s.basePath+"/command/shade_group", s.serveShadeGroup)
+		mux.HandleFunc(s.basePath+"/shades", s.serveShades)
 		mux.HandleFunc(s.basePath+"/scenes", s.serveScenes)
 		mux.HandleFunc(s.basePath+"/scene/activate", s.serveSceneActivate)
 		mux.HandleFunc(s.basePath+"/scene/activate_by_name", s.serveSceneActivateByName)
@@ -94,8 +231,8 @@ func (s *Server) addRoutes() *http.ServeMux {
 }
 
 func (s *Server) serveDevices(w http.ResponseWriter, r *http.Request) {
-	s.handleGetCall(w, func(conn BrokerConn) (any, int, error) {
-		devices, err := GetDevices(r.Context(), conn, s.state)
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		devices, err := s.getDevices(r.Context(), client)
 		if err != nil {
 			return nil, http.StatusInternalServerError, err
 		}
@@ -103,8 +240,116 @@ func (s *Server) serveDevices(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) serveDevice(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		id := r.PathValue("id")
+		if _, err := strconv.Atoi(id); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid device: %w", err)
+		}
+		devices, err := s.getDevices(r.Context(), client)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		for _, device := range devices {
+			if device.Href == "/device/"+id {
+				return device, http.StatusOK, nil
+			}
+		}
+		return nil, http.StatusNotFound, fmt.Errorf("unknown device: %s", id)
+	})
+}
+
+// deviceHealth describes a device which needs attention.
+type deviceHealth struct {
+	Href               string
+	FullyQualifiedName []string
+	DeviceType         string
+	Battery            string     `json:",omitempty"`
+	Availability       string     `json:",omitempty"`
+	LastSeen           *time.Time `json:",omitempty"`
+
+	// Problems are "LowBattery" and "Unreachable".
+	Problems []string
+}
+
+func (s *Server) serveDeviceHealth(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		devices, err := s.getDevices(r.Context(), client)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		results := []*deviceHealth{}
+		for _, device := range devices {
+			if problems := deviceProblems(device); len(problems) > 0 {
+				results = append(results, &deviceHealth{
+					Href:               device.Href,
+					FullyQualifiedName: device.FullyQualifiedName,
+					DeviceType:         device.DeviceType,
+					Battery:            device.Battery,
+					Availability:       device.Availability,
+					LastSeen:           device.LastSeen,
+					Problems:           problems,
+				})
+			}
+		}
+		return results, http.StatusOK, nil
+	})
+}
+
+// deviceProblems lists the reasons a device needs attention, if any.
+//
+// A device is unreachable if the bridge says so, or if the bridge cannot
+// tell the status of one of its zones.
+func deviceProblems(device *leap.DeviceInfo) []string {
+	var problems []string
+	if device.Battery == "Low" || device.Battery == "Empty" {
+		problems = append(problems, "LowBattery")
+	}
+	unreachable := device.Availability == "Unavailable"
+	for _, zone := range device.Zones {
+		if zone.StatusAccuracy != "" && zone.StatusAccuracy != "Good" {
+			unreachable = true
+		}
+	}
+	if unreachable {
+		problems = append(problems, "Unreachable")
+	}
+	return problems
+}
+
+func (s *Server) serveAreas(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		ctx, cancel := context.WithTimeout(r.Context(), DevicesTimeout)
+		defer cancel()
+		areas, err := s.live.Areas(ctx, client.Conn, client.Cache)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if areas == nil {
+			areas = []*leap.AreaInfo{}
+		}
+		return areas, http.StatusOK, nil
+	})
+}
+
+func (s *Server) serveOccupancy(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		ctx, cancel := context.WithTimeout(r.Context(), DevicesTimeout)
+		defer cancel()
+		groups, err := s.live.Occupancy(ctx, client.Conn, client.Cache)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if groups == nil {
+			groups = []*leap.OccupancyInfo{}
+		}
+		return groups, http.StatusOK, nil
+	})
+}
+
 func (s *Server) serveClearCache(w http.ResponseWriter, r *http.Request) {
 	s.state.ClearCache()
+	s.live.Invalidate()
 	if !s.state.CacheIsSaved() {
 		if err := s.state.Save(s.savePath); err != nil {
 			serveError(w, http.StatusInternalServerError, err)
@@ -115,87 +360,319 @@ func (s *Server) serveClearCache(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"data": true}`))
 }
 
+func (s *Server) serveStats(w http.ResponseWriter, r *http.Request) {
+	data, _ := json.Marshal(map[string]int64{
+		"CoalescedReads":       leap.CoalescedReads(),
+		"CoalescedDeviceLoads": s.devices.Coalesced(),
+		"CollapsedCommands":    s.commands.Collapsed(),
+	})
+	w.Header().Set("content-type", "application/json")
+	w.Write(data)
+}
+
+func (s *Server) serveDebugLEAP(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	trace := s.getTrace()
+	if trace == nil {
+		serveError(w, http.StatusNotFound, errors.New("tracing is disabled"))
+		return
+	}
+	// Like serveAdminLEAP, arguments only come from the query string.
+	query := r.URL.Query()
+	filter := leap.TraceFilter{
+		UrlPrefix:      query.Get("url_prefix"),
+		CommuniqueType: query.Get("type"),
+	}
+	follow, err := parseBool("follow", query.Get("follow"), false)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !follow {
+		entries := trace.Entries(filter)
+		if entries == nil {
+			entries = []leap.TraceEntry{}
+		}
+		data, _ := json.Marshal(entries)
+		w.Header().Set("content-type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		serveError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	tail := trace.Tail(r.Context())
+	w.Header().Set("content-type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(w)
+	for entry := range tail {
+		if !filter.Match(&entry) {
+			continue
+		}
+		if err := enc.Encode(entry); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) serveAdminLEAP(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	// Arguments come from the query string, since r.FormValue() would consume
+	// form-encoded request bodies.
+	communiqueType := r.URL.Query().Get("type")
+	if communiqueType == "" {
+		communiqueType = "ReadRequest"
+	}
+	switch communiqueType {
+	case "ReadRequest":
+	case "CreateRequest", "UpdateRequest":
+		if !s.adminWrites {
+			serveError(w, http.StatusForbidden, errors.New("admin writes are disabled"))
+			return
+		}
+		if r.Method != http.MethodPost {
+			serveError(w, http.StatusMethodNotAllowed, errors.New("writes must use POST"))
+			return
+		}
+	default:
+		serveError(w, http.StatusBadRequest, fmt.Errorf("unsupported request type: %s", communiqueType))
+		return
+	}
+	url := r.URL.Query().Get("url")
+	if !leapURLPattern.MatchString(url) {
+		serveError(w, http.StatusBadRequest, fmt.Errorf("invalid url: %q", url))
+		return
+	}
+	var body any
+	if communiqueType != "ReadRequest" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, MaxAdminBodySize+1))
+		if err == nil && len(data) > MaxAdminBodySize {
+			err = errors.New("body is too large")
+		} else if err == nil && !json.Valid(data) {
+			err = errors.New("body is not valid JSON")
+		}
+		if err != nil {
+			serveError(w, http.StatusBadRequest, err)
+			return
+		}
+		body = json.RawMessage(data)
+	}
+
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		ctx := r.Context()
+		if communiqueType != "ReadRequest" {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, CommandTimeout)
+			defer cancel()
+		}
+		response, err := leap.RawRequest(ctx, client.Conn, communiqueType, url, body)
+		var leapErr *leap.LEAPError
+		if errors.As(err, &leapErr) {
+			// The raw error response is more useful than our summary of it.
+			return response, leapErr.HTTPStatus(), nil
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return response, http.StatusOK, nil
+	})
+}
+
 func (s *Server) serveAllOff(w http.ResponseWriter, r *http.Request) {
-	s.handleGetCall(w, func(conn BrokerConn) (any, int, error) {
-		devices, err := GetDevices(r.Context(), conn, s.state)
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		fade, delay, err := fadeAndDelayParams(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		fans, err := boolParam(r, "fans", false)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		devices, err := s.getDevices(r.Context(), client)
 		if err != nil {
 			return nil, http.StatusInternalServerError, err
 		}
+		var commands []queuedCommand
 		for _, device := range devices {
-			if device.Zone == nil || *device.Zone == "" {
-				continue
-			}
-			if device.DeviceType == "QsWirelessShade" {
-				continue
-			}
-			commandType := "GoToDimmedLevel"
-			command := map[string]any{"CommandType": commandType}
-			if device.DeviceType == "WallSwitch" {
-				commandType = "GoToSwitchedLevel"
-				command["CommandType"] = commandType
-				command["SwitchedLevelParameters"] = map[string]any{
-					"SwitchedLevel": "Off",
+			for _, zone := range device.Zones {
+				controlType := zone.ControlType
+				if controlType == "" && device.Zone != nil && *device.Zone == zone.Href {
+					controlType = guessControlType(device.DeviceType)
 				}
-			} else {
-				command["DimmedLevelParameters"] = map[string]any{
-					"Level": 0,
+				isFan := controlType == "FanSpeed"
+				if !leap.IsLight(controlType) && !(fans && isFan) {
+					continue
 				}
-			}
-			body := map[string]any{"Command": command}
-			if err := CreateRequest(r.Context(), conn, *device.Zone+"/commandprocessor", body); err != nil {
-				return nil, http.StatusInternalServerError, err
+				target := leap.ZoneTarget{Level: 0}
+				if leap.SupportsFade(controlType) {
+					target.Fade = fade
+				}
+				if leap.SupportsDelay(controlType) {
+					target.Delay = delay
+				}
+				body, err := leap.ZoneCommand(controlType, target)
+				if err != nil {
+					return nil, http.StatusInternalServerError, err
+				}
+				commands = append(commands, queuedCommand{
+					Url:        zone.Href + "/commandprocessor",
+					Body:       body,
+					Idempotent: true,
+					Collapse:   "level",
+				})
 			}
 		}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, commands...); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
 		return map[string]bool{"data": true}, http.StatusOK, nil
 	})
 }
 
+// guessControlType guesses the control type of a device's primary zone from
+// the device type, for bridges which don't list their zones.
+func guessControlType(deviceType string) string {
+	switch deviceType {
+	case "QsWirelessShade":
+		return "Shade"
+	case "WallSwitch":
+		return "Switched"
+	default:
+		return "Dimmed"
+	}
+}
+
 func (s *Server) serveSetLevel(w http.ResponseWriter, r *http.Request) {
-	s.handleGetCall(w, func(conn BrokerConn) (any, int, error) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
 		commandType := r.FormValue("type")
 		zone := r.FormValue("zone")
 		if _, err := strconv.Atoi(zone); err != nil {
 			return nil, http.StatusBadRequest, fmt.Errorf("invalid zone: %w", err)
 		}
 
-		command := map[string]any{"CommandType": commandType}
+		zoneHref := "/zone/" + zone
+		fade, delay, err := fadeAndDelayParams(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		// The control type is only unknown on bridges which don't list their
+		// zones, in which case an explicit command type is trusted.
+		controlType, err := s.zoneControlType(r.Context(), client, zoneHref)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		// Absolute level commands can safely be repeated, but relative shade
+		// movements cannot.
+		idempotent := true
 
-		if commandType == "Raise" || commandType == "Lower" || commandType == "Stop" {
+		var body map[string]any
+		if isShadeMovement(commandType) {
+			if controlType != "" && !isShadeControlType(controlType) {
+				return nil, http.StatusBadRequest, fmt.Errorf("%s is not a shade", zoneHref)
+			}
 			// No additional parameters needed for these shade commands.
-		} else {
-			levelStr := r.FormValue("level")
-			level, err := strconv.Atoi(levelStr)
-			if err == nil && (level < 0 || level > 100) {
-				err = errors.New("level is out of range")
+			idempotent = false
+			if fade != 0 || delay != 0 {
+				return nil, http.StatusBadRequest, fmt.Errorf("%s does not support fade or delay", commandType)
 			}
-			if err != nil {
-				return nil, http.StatusBadRequest, fmt.Errorf("invalid level: %w", err)
+			body = map[string]any{"Command": map[string]any{"CommandType": commandType}}
+		} else {
+			var level int
+			if speed := r.FormValue("speed"); speed != "" {
+				if controlType != "FanSpeed" {
+					return nil, http.StatusBadRequest, fmt.Errorf("%s is not a fan", zoneHref)
+				}
+				// Fan speeds are sent as the corresponding level.
+				var ok bool
+				level, ok = leap.FanSpeedLevel(speed)
+				if !ok {
+					return nil, http.StatusBadRequest, fmt.Errorf("invalid speed: %s", speed)
+				}
+				if commandType == "" {
+					commandType = "GoToFanSpeed"
+				} else if commandType != "GoToFanSpeed" {
+					return nil, http.StatusBadRequest, fmt.Errorf("%s does not support speed", commandType)
+				}
+			} else if position := r.FormValue("position"); position != "" {
+				if controlType != "Shade" && controlType != "ShadeWithTilt" {
+					return nil, http.StatusBadRequest, fmt.Errorf("%s is not a shade", zoneHref)
+				}
+				level, err = s.shadePosition(zoneHref, position)
+				if err != nil {
+					return nil, http.StatusBadRequest, err
+				}
+			} else {
+				level, err = strconv.Atoi(r.FormValue("level"))
+				if err == nil && (level < 0 || level > 100) {
+					err = errors.New("level is out of range")
+				}
+				if err != nil {
+					return nil, http.StatusBadRequest, fmt.Errorf("invalid level: %w", err)
+				}
 			}
 
 			if commandType == "GoToLevel" {
-				command["Parameter"] = map[string]any{
-					"Type":  "Level",
-					"Value": level,
-				}
-			} else if commandType == "GoToDimmedLevel" {
-				command["DimmedLevelParameters"] = map[string]any{
-					"Level": level,
+				if controlType != "" && !leap.SupportsLevel(controlType) {
+					return nil, http.StatusBadRequest, fmt.Errorf("%s zones do not support levels", controlType)
 				}
-			} else if commandType == "GoToSwitchedLevel" {
-				name := "On"
-				if level == 0 {
-					name = "Off"
+				if fade != 0 || delay != 0 {
+					return nil, http.StatusBadRequest, errors.New("GoToLevel does not support fade or delay")
 				}
-				command["SwitchedLevelParameters"] = map[string]any{
-					"SwitchedLevel": name,
+				body = map[string]any{
+					"Command": map[string]any{
+						"CommandType": commandType,
+						"Parameter": map[string]any{
+							"Type":  "Level",
+							"Value": level,
+						},
+					},
 				}
 			} else {
-				return nil, http.StatusBadRequest, fmt.Errorf("unknown command type: %s", commandType)
+				if commandType != "" {
+					expected, ok := commandControlTypes[commandType]
+					if !ok {
+						return nil, http.StatusBadRequest, fmt.Errorf("unknown command type: %s", commandType)
+					} else if controlType != "" && controlType != expected {
+						return nil, http.StatusBadRequest,
+							fmt.Errorf("%s is for %s zones, but %s is %s", commandType, expected, zoneHref, controlType)
+					}
+					controlType = expected
+				} else if controlType == "" {
+					return nil, http.StatusBadRequest,
+						fmt.Errorf("control type of %s is unknown, so a command type is required", zoneHref)
+				}
+				target := leap.ZoneTarget{Level: level, Fade: fade, Delay: delay}
+				body, err = leap.ZoneCommand(controlType, target)
+				if err != nil {
+					return nil, http.StatusBadRequest, err
+				}
 			}
 		}
 
-		body := map[string]any{"Command": command}
-		if err := CreateRequest(r.Context(), conn, "/zone/"+zone+"/commandprocessor", body); err == nil {
+		cmd := queuedCommand{Url: zoneHref + "/commandprocessor", Body: body, Idempotent: idempotent}
+		if idempotent {
+			cmd.Collapse = "level"
+		}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, cmd); err == nil {
 			return true, http.StatusOK, nil
 		} else {
 			return false, http.StatusInternalServerError, err
@@ -203,8 +680,73 @@ func (s *Server) serveSetLevel(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) serveSetColor(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		zone := r.FormValue("zone")
+		if _, err := strconv.Atoi(zone); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid zone: %w", err)
+		}
+
+		var target leap.ColorTarget
+		var kelvin, hue, saturation *int
+		for _, param := range []struct {
+			name string
+			dest **int
+		}{
+			{"kelvin", &kelvin},
+			{"hue", &hue},
+			{"saturation", &saturation},
+			{"vibrancy", &target.Vibrancy},
+			{"level", &target.Level},
+		} {
+			if *param.dest, err = intParam(r, param.name); err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+		}
+		if kelvin != nil {
+			target.Kelvin = *kelvin
+		}
+		if (hue == nil) != (saturation == nil) {
+			return nil, http.StatusBadRequest, errors.New("hue and saturation must be passed together")
+		} else if hue != nil {
+			target.HSV = &leap.HSVTuningLevel{Hue: *hue, Saturation: *saturation}
+		}
+		if target.Fade, err = durationParam(r, "fade"); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		zoneHref := "/zone/" + zone
+		info, err := s.zoneInfo(r.Context(), client, zoneHref)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		body, err := leap.ColorCommand(info.ControlType, info.KelvinRange, target)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		cmd := queuedCommand{
+			Url:        zoneHref + "/commandprocessor",
+			Body:       body,
+			Idempotent: true,
+			Collapse:   "color",
+		}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, cmd); err != nil {
+			return false, http.StatusInternalServerError, err
+		}
+		return true, http.StatusOK, nil
+	})
+}
+
 func (s *Server) servePressAndRelease(w http.ResponseWriter, r *http.Request) {
-	s.handleGetCall(w, func(conn BrokerConn) (any, int, error) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
 		button := r.FormValue("button")
 		if _, err := strconv.Atoi(button); err != nil {
 			return nil, http.StatusBadRequest, fmt.Errorf("invalid button: %w", err)
@@ -215,7 +757,8 @@ func (s *Server) servePressAndRelease(w http.ResponseWriter, r *http.Request) {
 				"CommandType": "PressAndRelease",
 			},
 		}
-		if err := CreateRequest(r.Context(), conn, "/button/"+button+"/commandprocessor", body); err == nil {
+		cmd := queuedCommand{Url: "/button/" + button + "/commandprocessor", Body: body}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, cmd); err == nil {
 			return true, http.StatusOK, nil
 		} else {
 			return false, http.StatusInternalServerError, err
@@ -224,24 +767,21 @@ func (s *Server) servePressAndRelease(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) serveScenes(w http.ResponseWriter, r *http.Request) {
-	s.handleGetCall(w, func(conn BrokerConn) (any, int, error) {
-		var response struct {
-			VirtualButtons []struct {
-				Href         string `json:"href"`
-				Name         string
-				IsProgrammed bool
-				ButtonNumber int
-			}
-		}
-		if err := ReadRequest(r.Context(), conn, "/virtualbutton", &response); err != nil {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		scenes, err := client.VirtualButtons(r.Context())
+		if err != nil {
 			return nil, http.StatusInternalServerError, err
 		}
-		return response.VirtualButtons, http.StatusOK, nil
+		return scenes, http.StatusOK, nil
 	})
 }
 
 func (s *Server) serveSceneActivate(w http.ResponseWriter, r *http.Request) {
-	s.handleGetCall(w, func(conn BrokerConn) (any, int, error) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
 		scene := r.FormValue("scene")
 		if _, err := strconv.Atoi(scene); err != nil {
 			return nil, http.StatusBadRequest, fmt.Errorf("invalid scene: %w", err)
@@ -251,7 +791,8 @@ func (s *Server) serveSceneActivate(w http.ResponseWriter, r *http.Request) {
 				"CommandType": "PressAndRelease",
 			},
 		}
-		if err := CreateRequest(r.Context(), conn, "/virtualbutton/"+scene+"/commandprocessor", body); err == nil {
+		cmd := queuedCommand{Url: "/virtualbutton/" + scene + "/commandprocessor", Body: body}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, cmd); err == nil {
 			return true, http.StatusOK, nil
 		} else {
 			return false, http.StatusInternalServerError, err
@@ -260,23 +801,21 @@ func (s *Server) serveSceneActivate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) serveSceneActivateByName(w http.ResponseWriter, r *http.Request) {
-	s.handleGetCall(w, func(conn BrokerConn) (any, int, error) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
 		sceneName := r.FormValue("name")
 		if sceneName == "" {
 			return map[string]bool{"data": false}, http.StatusOK, nil
 		}
-		var response struct {
-			VirtualButtons []struct {
-				Href         string `json:"href"`
-				Name         string
-				IsProgrammed bool
-			}
-		}
-		if err := ReadRequest(r.Context(), conn, "/virtualbutton", &response); err != nil {
+		scenes, err := client.VirtualButtons(r.Context())
+		if err != nil {
 			return nil, http.StatusInternalServerError, err
 		}
 		var href string
-		for _, scene := range response.VirtualButtons {
+		for _, scene := range scenes {
 			if !scene.IsProgrammed {
 				continue
 			}
@@ -293,7 +832,8 @@ func (s *Server) serveSceneActivateByName(w http.ResponseWriter, r *http.Request
 				"CommandType": "PressAndRelease",
 			},
 		}
-		if err := CreateRequest(r.Context(), conn, href+"/commandprocessor", body); err == nil {
+		cmd := queuedCommand{Url: href + "/commandprocessor", Body: body}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, cmd); err == nil {
 			return map[string]bool{"data": true}, http.StatusOK, nil
 		} else {
 			return nil, http.StatusInternalServerError, err
@@ -301,15 +841,207 @@ func (s *Server) serveSceneActivateByName(w http.ResponseWriter, r *http.Request
 	})
 }
 
-func (s *Server) handleGetCall(w http.ResponseWriter, f func(conn BrokerConn) (any, int, error)) {
+// getDevices gets the current devices, sharing the result between concurrent
+// callers.
+func (s *Server) getDevices(ctx context.Context, client *leap.Client) ([]*leap.DeviceInfo, error) {
+	return s.devices.Do(ctx, leap.ConnKey(client.Conn, "devices"), func(ctx context.Context) ([]*leap.DeviceInfo, error) {
+		ctx, cancel := context.WithTimeout(ctx, DevicesTimeout)
+		defer cancel()
+		return s.live.Devices(ctx, client.Conn, client.Cache)
+	})
+}
+
+// zoneControlType finds the ControlType of a zone, preferring the live device
+// state over reading the zone from the bridge.
+func (s *Server) zoneControlType(ctx context.Context, client *leap.Client, href string) (string, error) {
+	zone, err := s.zoneInfo(ctx, client, href)
+	if err != nil {
+		return "", err
+	}
+	return zone.ControlType, nil
+}
+
+// zoneInfo looks up a zone in the device list, or reads it from the bridge
+// if it is not listed.
+func (s *Server) zoneInfo(ctx context.Context, client *leap.Client, href string) (*leap.ZoneInfo, error) {
+	if devices, err := s.getDevices(ctx, client); err == nil {
+		for _, device := range devices {
+			for _, zone := range device.Zones {
+				if zone.Href == href && zone.ControlType != "" {
+					return zone, nil
+				}
+			}
+		}
+	}
+	zone, err := client.Zone(ctx, href)
+	if err != nil {
+		return nil, err
+	}
+	info := &leap.ZoneInfo{Href: zone.Href, Name: zone.Name, ControlType: zone.ControlType}
+	if zone.ColorTuningProperties != nil {
+		info.KelvinRange = zone.ColorTuningProperties.WhiteTuningLevelRange
+	}
+	return info, nil
+}
+
+// waitParam parses the optional "wait" argument of command endpoints, which
+// defaults to true.
+func waitParam(r *http.Request) (bool, error) {
+	return boolParam(r, "wait", true)
+}
+
+// boolParam parses an optional boolean argument.
+func boolParam(r *http.Request, name string, defaultValue bool) (bool, error) {
+	return parseBool(name, r.FormValue(name), defaultValue)
+}
+
+// parseBool parses the value of an optional boolean argument.
+func parseBool(name, value string, defaultValue bool) (bool, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// A queuedCommand is a CreateRequest for a command processor.
+type queuedCommand struct {
+	Url  string
+	Body any
+
+	// Idempotent commands, such as absolute levels, may be retried.
+	Idempotent bool
+
+	// Collapse is the group of idempotent commands, such as "level" or
+	// "color", which may be replaced by a newer command of the same group for
+	// the same URL before being sent. If empty, the command is always sent.
+	//
+	// Groups are separate from CommandTypes because, e.g., color and level
+	// commands share a CommandType but change different things.
+	Collapse string
+
+	// Description, if set, prefixes errors from the command, so that callers
+	// can tell which of several commands failed.
+	Description string
+}
+
+// queueCommands sends commands through the command queue, which serializes
+// commands to each zone, drops superseded levels, and limits the overall
+// command rate.
+//
+// If wait is true, this waits for the bridge to accept or reject every
+// command. Otherwise, it returns as soon as the commands are queued.
+func (s *Server) queueCommands(ctx context.Context, conn leap.BrokerConn, wait bool, commands ...queuedCommand) error {
+	results := make([]<-chan error, len(commands))
+	for i, cmd := range commands {
+		results[i] = s.commands.Submit(cmd.Url, cmd.Collapse, func(ctx context.Context) error {
+			return sendCommand(ctx, conn, cmd.Url, cmd.Body, cmd.Idempotent)
+		})
+	}
+	if !wait {
+		return nil
+	}
+	var errs []error
+	for i, result := range results {
+		select {
+		case err := <-result:
+			if err != nil && commands[i].Description != "" {
+				errs = append(errs, fmt.Errorf("%s: %w", commands[i].Description, err))
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fadeAndDelayParams parses the optional "fade" and "delay" arguments of level
+// commands, which are either seconds (e.g. "5") or Go durations (e.g. "1m30s").
+func fadeAndDelayParams(r *http.Request) (fade, delay time.Duration, err error) {
+	fade, err = durationParam(r, "fade")
+	if err != nil {
+		return
+	}
+	delay, err = durationParam(r, "delay")
+	return
+}
+
+// intParam parses an optional integer argument, returning nil if it is
+// missing.
+func intParam(r *http.Request, name string) (*int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return &result, nil
+}
+
+// durationParam parses an optional duration argument, which defaults to 0.
+func durationParam(r *http.Request, name string) (time.Duration, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+	outOfRange := fmt.Errorf("invalid %s: %s is out of range", name, value)
+	var d time.Duration
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		// Check before converting, since the conversion can overflow.
+		if math.IsNaN(secs) || math.IsInf(secs, 0) || secs < 0 || secs > leap.MaxDuration.Seconds() {
+			return 0, outOfRange
+		}
+		d = time.Duration(secs * float64(time.Second))
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	} else if d < 0 || d > leap.MaxDuration {
+		return 0, outOfRange
+	}
+	if _, err := leap.FormatDuration(d); err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// sendCommand sends a CreateRequest for a command and waits up to
+// CommandTimeout for the bridge to accept or reject it.
+//
+// If idempotent is true, attempts that time out are retried according to
+// leap.DefaultRetryPolicy within the same CommandTimeout, with each attempt
+// getting an equal share of it.
+func sendCommand(ctx context.Context, conn leap.BrokerConn, url string, body any, idempotent bool) error {
+	ctx, cancel := context.WithTimeout(ctx, CommandTimeout)
+	defer cancel()
+	if idempotent {
+		policy := leap.DefaultRetryPolicy
+		policy.AttemptTimeout = CommandTimeout / time.Duration(max(1, policy.MaxAttempts))
+		return leap.AckCreateRequestWithRetry(ctx, conn, url, body, policy)
+	}
+	return leap.AckCreateRequest(ctx, conn, url, body)
+}
+
+func (s *Server) handleGetCall(w http.ResponseWriter, f func(client *leap.Client) (any, int, error)) {
 	conn, err := s.getConnection()
 	w.Header().Set("content-type", "application/json")
 	if err != nil {
 		serveError(w, http.StatusInternalServerError, err)
 		return
 	}
-	obj, status, err := f(conn)
+	client := leap.NewClient(conn, s.state)
+	client.Logger = log.Default()
+	obj, status, err := f(client)
 	if err != nil {
+		var leapErr *leap.LEAPError
+		if errors.As(err, &leapErr) {
+			status = leapErr.HTTPStatus()
+		}
 		serveError(w, status, err)
 		return
 	}
@@ -328,7 +1060,7 @@ func (s *Server) handleGetCall(w http.ResponseWriter, f func(conn BrokerConn) (a
 	w.Write(data)
 }
 
-func (s *Server) getConnection() (conn BrokerConn, err error) {
+func (s *Server) getConnection() (conn leap.BrokerConn, err error) {
 	s.sessionLock.RLock()
 	if s.connection != nil && s.connection.Error() == nil {
 		s.sessionLock.RUnlock()
@@ -363,6 +1095,7 @@ func (s *Server) getConnection() (conn BrokerConn, err error) {
 			log.Println("established new broker connection")
 			s.connection = conn
 			go s.pingLoop(conn)
+			go s.syncLiveState(conn)
 		}
 		s.sessionLock.Unlock()
 	}()
@@ -373,6 +1106,19 @@ func (s *Server) getConnection() (conn BrokerConn, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), ConnectionTimeout)
 	defer cancel()
 
+	conn, err = s.connector(ctx)
+	if err == nil && s.recorder != nil {
+		conn = s.recorder.Wrap(conn)
+	}
+	if err == nil && s.trace != nil {
+		conn = s.trace.Wrap(conn)
+	}
+	return
+}
+
+// connectCloud connects to the Lutron cloud broker, authenticating if there
+// are no saved credentials or if the saved credentials are rejected.
+func (s *Server) connectCloud(ctx context.Context) (leap.BrokerConn, error) {
 	recreateCreds := func() (*lutronbroker.BrokerCredentials, error) {
 		token, err := lutronbroker.GetOAuthToken(ctx, s.username, s.password)
 		if err != nil {
@@ -411,15 +1157,18 @@ func (s *Server) getConnection() (conn BrokerConn, err error) {
 	creds := s.state.BrokerCreds()
 	didAuth := creds == nil
 	if didAuth {
+		var err error
 		creds, err = recreateCreds()
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	conn, err = lutronbroker.NewBrokerConnection[Message](ctx, creds)
-	if err == nil || didAuth {
-		return
+	conn, err := lutronbroker.NewBrokerConnection[leap.Message](ctx, creds)
+	if err == nil {
+		return conn, nil
+	} else if didAuth {
+		return nil, err
 	}
 
 	// Our session might have expired, so let's try reauthenticating.
@@ -427,11 +1176,37 @@ func (s *Server) getConnection() (conn BrokerConn, err error) {
 	if err != nil {
 		return nil, err
 	}
-	conn, err = lutronbroker.NewBrokerConnection[Message](ctx, creds)
-	return
+	conn, err = lutronbroker.NewBrokerConnection[leap.Message](ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connectLocal connects directly to a bridge using saved credentials.
+func (s *Server) connectLocal(ctx context.Context, addr string) (leap.BrokerConn, error) {
+	creds := s.state.LocalCreds()
+	if creds == nil {
+		return nil, errors.New("not paired with a local bridge")
+	}
+	conn, err := leap.DialLocal(ctx, addr, creds)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
 }
 
-func (s *Server) pingLoop(conn BrokerConn) {
+// syncLiveState loads device state for a new connection in the background,
+// so that the next request for devices can be answered from memory.
+func (s *Server) syncLiveState(conn leap.BrokerConn) {
+	ctx, cancel := context.WithTimeout(context.Background(), DevicesTimeout)
+	defer cancel()
+	if err := s.live.Sync(ctx, conn, s.state); err != nil {
+		log.Println("error synchronizing live state:", err)
+	}
+}
+
+func (s *Server) pingLoop(conn leap.BrokerConn) {
 	ticker := time.NewTicker(PingInterval)
 	defer ticker.Stop()
 	for range ticker.C {
@@ -442,11 +1217,16 @@ func (s *Server) pingLoop(conn BrokerConn) {
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), PingTimeout)
 		var response any
-		err := ReadRequest(ctx, conn, "/server/1/status/ping", &response)
+		err := leap.ReadRequest(ctx, conn, "/server/1/status/ping", &response)
 		cancel()
+		var leapErr *leap.LEAPError
 		if conn.Error() != nil {
 			// See comment above; this is handled already.
 			return
+		} else if errors.As(err, &leapErr) {
+			// The bridge answered, so the connection is alive even if it
+			// doesn't support pings (e.g. a recording without one).
+			continue
 		} else if err != nil {
 			// There's some error (e.g. a timeout) that the BrokerConn missed.
 			s.sessionLock.Lock()
@@ -463,6 +1243,26 @@ func (s *Server) pingLoop(conn BrokerConn) {
 	}
 }
 
+// checkAdmin verifies the admin token of a request, writing an error
+// response and returning false if it is missing or wrong.
+//
+// The token may be passed as a bearer token or as the "token" argument.
+func (s *Server) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		serveError(w, http.StatusNotFound, errors.New("admin endpoints are disabled"))
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		serveError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+		return false
+	}
+	return true
+}
+
 func serveError(w http.ResponseWriter, status int, err error) {
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(status)
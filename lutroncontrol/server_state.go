@@ -7,22 +7,17 @@ import (
 
 	"github.com/unixpickle/essentials"
 	"github.com/unixpickle/lutronbroker/lutronbroker"
+	"github.com/unixpickle/lutroncontrol/leap"
 )
 
-// Cache is an interface for a KV store that is implemented by ServerState.
-type Cache interface {
-	GetCache(key string, out any) bool
-	SetCache(key string, obj any)
-	ClearCache()
-}
-
 // ServerState is the state (e.g. session info) that the server saves across
-// runs.
+// runs. It implements leap.Cache.
 //
 // Methods are safe to call concurrently from multiple Goroutines.
 type ServerState struct {
 	lock         sync.Mutex
 	brokerCreds  *lutronbroker.BrokerCredentials
+	localCreds   *leap.LocalCredentials
 	cache        map[string]json.RawMessage
 	cacheIsSaved bool
 }
@@ -43,6 +38,7 @@ func NewServerState(path string) (state *ServerState, err error) {
 	}
 	var obj struct {
 		BrokerCreds *lutronbroker.BrokerCredentials
+		LocalCreds  *leap.LocalCredentials
 		Cache       map[string]json.RawMessage
 	}
 	if err := json.Unmarshal(data, &obj); err != nil {
@@ -51,7 +47,12 @@ func NewServerState(path string) (state *ServerState, err error) {
 	if obj.Cache == nil {
 		obj.Cache = map[string]json.RawMessage{}
 	}
-	return &ServerState{brokerCreds: obj.BrokerCreds, cache: obj.Cache, cacheIsSaved: true}, nil
+	return &ServerState{
+		brokerCreds:  obj.BrokerCreds,
+		localCreds:   obj.LocalCreds,
+		cache:        obj.Cache,
+		cacheIsSaved: true,
+	}, nil
 }
 
 func (s *ServerState) BrokerCreds() *lutronbroker.BrokerCredentials {
@@ -66,6 +67,20 @@ func (s *ServerState) SetBrokerCreds(b *lutronbroker.BrokerCredentials) {
 	s.brokerCreds = b
 }
 
+// LocalCreds returns the credentials for connecting directly to a bridge, or
+// nil if the server has not paired with one.
+func (s *ServerState) LocalCreds() *leap.LocalCredentials {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.localCreds
+}
+
+func (s *ServerState) SetLocalCreds(c *leap.LocalCredentials) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.localCreds = c
+}
+
 // GetCache gets an object stored under the given key.
 func (s *ServerState) GetCache(key string, out any) bool {
 	s.lock.Lock()
@@ -123,9 +138,11 @@ func (s *ServerState) Save(path string) (err error) {
 
 	var obj struct {
 		BrokerCreds *lutronbroker.BrokerCredentials
+		LocalCreds  *leap.LocalCredentials
 		Cache       map[string]json.RawMessage
 	}
 	obj.BrokerCreds = s.brokerCreds
+	obj.LocalCreds = s.localCreds
 	obj.Cache = s.cache
 
 	data, err := json.Marshal(obj)
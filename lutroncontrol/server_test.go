@@ -0,0 +1,1190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/lutroncontrol/leap"
+	"github.com/unixpickle/lutroncontrol/leap/leaptest"
+)
+
+func newTestServer(t *testing.T, house *leaptest.House) (*Server, *leaptest.Broker, http.Handler) {
+	dir := t.TempDir()
+	server, err := NewServer(dir, filepath.Join(dir, "state.json"), "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := leaptest.NewBroker(house)
+	server.connection = broker
+	t.Cleanup(func() {
+		broker.Close()
+	})
+	return server, broker, server.addRoutes()
+}
+
+func getJSON(t *testing.T, handler http.Handler, path string, out any) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("content-type"); ct != "application/json" {
+		t.Fatalf("%s: unexpected content type %q", path, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("%s: decode response %q: %s", path, rec.Body.String(), err)
+	}
+	return rec.Code
+}
+
+func findDevice(devices []*leap.DeviceInfo, name string) *leap.DeviceInfo {
+	for _, d := range devices {
+		if d.FullyQualifiedName[len(d.FullyQualifiedName)-1] == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func TestServeDevices(t *testing.T) {
+	_, _, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var devices []*leap.DeviceInfo
+	if code := getJSON(t, handler, "/devices", &devices); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if len(devices) != 5 {
+		t.Fatalf("expected 5 devices but got %d", len(devices))
+	}
+
+	island := findDevice(devices, "Island")
+	if island == nil || island.Zone == nil || *island.Zone != "/zone/1" {
+		t.Fatalf("unexpected island device: %+v", island)
+	}
+	if island.Level == nil || *island.Level != 50 {
+		t.Errorf("unexpected island level: %v", island.Level)
+	}
+
+	bridge := findDevice(devices, "Smart Bridge")
+	if bridge.Zone != nil || bridge.Level != nil {
+		t.Errorf("bridge should not have a zone: %+v", bridge)
+	}
+
+	pico := findDevice(devices, "Pico")
+	if len(pico.Buttons) != 2 {
+		t.Fatalf("expected 2 pico buttons but got %d", len(pico.Buttons))
+	}
+	model := pico.Buttons[0].ProgrammingModel
+	if model == nil || model.Preset == nil {
+		t.Fatalf("missing preset for pico button: %+v", pico.Buttons[0])
+	}
+	assignments := model.Preset.DimmedLevelAssignments
+	if len(assignments) != 1 || assignments[0].Level != 75 || assignments[0].FadeTime != "00:00:02" {
+		t.Errorf("unexpected dimmed level assignments: %+v", assignments)
+	}
+}
+
+func TestServeDevice(t *testing.T) {
+	_, _, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var island leap.DeviceInfo
+	if code := getJSON(t, handler, "/devices/2", &island); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if island.Href != "/device/2" || island.Name != "Island" || island.SerialNumber != 12345678 ||
+		island.ModelNumber != "PD-6WCL-XX" || island.Firmware != "001.005.000r000" ||
+		island.AddressedState != "Addressed" || island.Area == nil || *island.Area != "/area/2" ||
+		len(island.Zones) != 1 || island.Zones[0].Href != "/zone/1" {
+		t.Errorf("unexpected island: %+v", island)
+	}
+
+	var pico leap.DeviceInfo
+	getJSON(t, handler, "/devices/5", &pico)
+	if len(pico.ButtonGroups) != 1 || pico.ButtonGroups[0] != "/device/5/buttongroup" {
+		t.Errorf("unexpected pico button groups: %v", pico.ButtonGroups)
+	}
+
+	var result map[string]any
+	if code := getJSON(t, handler, "/devices/99", &result); code != http.StatusNotFound {
+		t.Errorf("unexpected status for missing device: %d", code)
+	}
+	if code := getJSON(t, handler, "/devices/abc", &result); code != http.StatusBadRequest {
+		t.Errorf("unexpected status for invalid device: %d", code)
+	}
+}
+
+func TestServeDevicesCaching(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	for i := 0; i < 2; i++ {
+		var devices []*leap.DeviceInfo
+		if code := getJSON(t, handler, "/devices", &devices); code != http.StatusOK {
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if n := broker.RequestCount("/preset/1"); n != 1 {
+		t.Errorf("expected one preset read but got %d", n)
+	}
+	if n := broker.RequestCount("/device"); n != 1 {
+		t.Errorf("expected devices to be served from memory, but got %d reads", n)
+	}
+}
+
+func TestServeDeviceHealth(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var health []map[string]any
+	if code := getJSON(t, handler, "/health/devices", &health); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if len(health) != 0 {
+		t.Errorf("expected healthy devices but got %v", health)
+	}
+
+	broker.SetDeviceStatus("/device/5", "Low", "Available")
+	broker.SetDeviceStatus("/device/4", "Normal", "Unavailable")
+	var results []struct {
+		Href     string
+		Battery  string
+		LastSeen *time.Time
+		Problems []string
+	}
+	leaptest.WaitFor(t, func() bool {
+		getJSON(t, handler, "/health/devices", &results)
+		return len(results) == 2
+	})
+	if results[0].Href != "/device/4" || !slices.Equal(results[0].Problems, []string{"Unreachable"}) {
+		t.Errorf("unexpected shade health: %+v", results[0])
+	}
+	if results[1].Href != "/device/5" || results[1].Battery != "Low" ||
+		!slices.Equal(results[1].Problems, []string{"LowBattery"}) || results[1].LastSeen == nil {
+		t.Errorf("unexpected pico health: %+v", results[1])
+	}
+}
+
+func TestServeAreas(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var areas []*leap.AreaInfo
+	if code := getJSON(t, handler, "/areas", &areas); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if len(areas) != 1 || len(areas[0].Areas) != 2 || areas[0].OnCount != 2 {
+		t.Fatalf("unexpected areas: %+v", areas)
+	}
+	livingRoom := areas[0].Areas[0].Areas[1]
+	if livingRoom.Name != "Living Room" || len(livingRoom.Devices) != 1 || *livingRoom.Devices[0].Area != "/area/3" {
+		t.Errorf("unexpected living room: %+v", livingRoom)
+	}
+
+	// Levels follow status updates.
+	broker.SetZoneLevel("/zone/2", 0)
+	leaptest.WaitFor(t, func() bool {
+		areas = nil
+		getJSON(t, handler, "/areas", &areas)
+		return areas[0].OnCount == 1 && *areas[0].AverageLevel == 25
+	})
+}
+
+func TestServeOccupancy(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var groups []*leap.OccupancyInfo
+	if code := getJSON(t, handler, "/occupancy", &groups); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if len(groups) != 1 || groups[0].Status != "Unoccupied" {
+		t.Fatalf("unexpected occupancy groups: %+v", groups)
+	}
+
+	broker.SetOccupancy("/occupancygroup/1", "Occupied")
+	var areas []*leap.AreaInfo
+	leaptest.WaitFor(t, func() bool {
+		areas = nil
+		getJSON(t, handler, "/areas", &areas)
+		return areas[0].Areas[0].Areas[0].Occupancy == "Occupied"
+	})
+	if kitchen := areas[0].Areas[0].Areas[0]; kitchen.Name != "Kitchen" {
+		t.Errorf("unexpected area: %+v", kitchen)
+	}
+	if livingRoom := areas[0].Areas[0].Areas[1]; livingRoom.Occupancy != "" {
+		t.Errorf("unexpected living room occupancy: %q", livingRoom.Occupancy)
+	}
+
+	getJSON(t, handler, "/occupancy", &groups)
+	if groups[0].LastOccupied == nil || groups[0].LastUnoccupied != nil {
+		t.Errorf("unexpected timestamps: %+v", groups[0])
+	}
+}
+
+func TestServeScenes(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var scenes []struct {
+		Href         string `json:"href"`
+		Name         string
+		IsProgrammed bool
+		ButtonNumber int
+	}
+	if code := getJSON(t, handler, "/scenes", &scenes); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if len(scenes) != 2 {
+		t.Fatalf("expected 2 scenes but got %d", len(scenes))
+	}
+	if scenes[0].Href != "/virtualbutton/1" || scenes[0].Name != "Movie Night" || !scenes[0].IsProgrammed {
+		t.Errorf("unexpected first scene: %+v", scenes[0])
+	}
+	if scenes[1].IsProgrammed {
+		t.Errorf("second scene should not be programmed")
+	}
+
+	var result map[string]bool
+	if code := getJSON(t, handler, "/scene/activate_by_name?name=movie+night", &result); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	} else if !result["data"] {
+		t.Fatal("scene was not found")
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.ZoneLevel("/zone/1") == 10 && broker.ZoneLevel("/zone/2") == 0
+	})
+}
+
+func TestServeSetLevel(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var result bool
+	path := "/command/set_level?type=GoToDimmedLevel&zone=1&level=30"
+	if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+		t.Fatalf("unexpected response: status=%d result=%v", code, result)
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.ZoneLevel("/zone/1") == 30
+	})
+
+	path = "/command/set_level?type=GoToSwitchedLevel&zone=2&level=0"
+	if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+		t.Fatalf("unexpected response: status=%d result=%v", code, result)
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.ZoneLevel("/zone/2") == 0
+	})
+
+	var devices []*leap.DeviceInfo
+	getJSON(t, handler, "/devices", &devices)
+	if level := findDevice(devices, "Island").Level; level == nil || *level != 30 {
+		t.Errorf("unexpected island level after command: %v", level)
+	}
+
+	for _, path := range []string{
+		"/command/set_level?type=GoToDimmedLevel&zone=abc&level=30",
+		"/command/set_level?type=GoToDimmedLevel&zone=1&level=101",
+		"/command/set_level?type=GoToDimmedLevel&zone=1",
+		"/command/set_level?type=Explode&zone=1&level=3",
+	} {
+		var errResult map[string]string
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", path, code)
+		} else if errResult["error"] == "" {
+			t.Errorf("%s: missing error message", path)
+		}
+	}
+}
+
+func TestServeAllOff(t *testing.T) {
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Ceiling Fan"},
+		DeviceType:         "FanSpeedController",
+		Area:               "/area/4",
+		Zones: []*leaptest.Zone{
+			{Href: "/zone/4", Name: "Fan Light", ControlType: "SpectrumTune", Level: 30},
+			{Href: "/zone/5", Name: "Fan", ControlType: "FanSpeed", Level: 50},
+		},
+	}, &leaptest.Device{
+		Href:               "/device/7",
+		FullyQualifiedName: []string{"Garage", "Door"},
+		DeviceType:         "CCO",
+		Zones: []*leaptest.Zone{
+			{Href: "/zone/6", Name: "Door", ControlType: "CCO", Level: 100},
+		},
+	})
+	_, broker, handler := newTestServer(t, house)
+
+	var result map[string]bool
+	if code := getJSON(t, handler, "/command/all_off?fade=3&delay=1", &result); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	} else if !result["data"] {
+		t.Fatal("unexpected all_off result")
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.ZoneLevel("/zone/1") == 0 && broker.ZoneLevel("/zone/2") == 0 &&
+			broker.ZoneLevel("/zone/4") == 0
+	})
+	for zone, level := range map[string]int{"/zone/3": 40, "/zone/5": 50, "/zone/6": 100} {
+		if actual := broker.ZoneLevel(zone); actual != level {
+			t.Errorf("%s is not a light, but its level changed to %d", zone, actual)
+		}
+	}
+
+	// Switched zones get the delay, but cannot fade.
+	for _, msg := range broker.Requests() {
+		if !strings.HasSuffix(msg.Header.Url, "/commandprocessor") {
+			continue
+		}
+		body := string(msg.Body)
+		hasFade := strings.Contains(body, `"FadeTime":"00:00:03"`)
+		if hasFade != (msg.Header.Url != "/zone/2/commandprocessor") ||
+			!strings.Contains(body, `"DelayTime":"00:00:01"`) {
+			t.Errorf("unexpected command for %s: %s", msg.Header.Url, body)
+		}
+	}
+}
+
+func TestServeAllOffWithoutZones(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+	broker.FailURL("/zone", "404 NotFound")
+
+	// Without zone definitions, control types are guessed from device types.
+	var result map[string]bool
+	if code := getJSON(t, handler, "/command/all_off", &result); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	} else if !result["data"] {
+		t.Fatal("unexpected all_off result")
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.ZoneLevel("/zone/1") == 0 && broker.ZoneLevel("/zone/2") == 0
+	})
+	if level := broker.ZoneLevel("/zone/3"); level != 40 {
+		t.Errorf("shade level changed to %d", level)
+	}
+	for _, msg := range broker.Requests() {
+		if msg.Header.Url == "/zone/2/commandprocessor" &&
+			!strings.Contains(string(msg.Body), "GoToSwitchedLevel") {
+			t.Errorf("unexpected command for switched zone: %s", msg.Body)
+		}
+	}
+}
+
+func TestServeSetLevelControlType(t *testing.T) {
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Lamp"},
+		DeviceType:         "PlugInDimmer",
+		Zones:              []*leaptest.Zone{{Href: "/zone/4", Name: "Lamp", Level: 10}},
+	})
+	_, broker, handler := newTestServer(t, house)
+
+	// Without a type, the command depends on the zone's control type.
+	var result bool
+	for _, c := range []struct {
+		zone  string
+		level int
+	}{{"1", 20}, {"2", 0}, {"3", 70}} {
+		path := fmt.Sprintf("/command/set_level?zone=%s&level=%d", c.zone, c.level)
+		if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+			t.Fatalf("%s: unexpected response: status=%d result=%v", path, code, result)
+		}
+		if level := broker.ZoneLevel("/zone/" + c.zone); level != c.level {
+			t.Errorf("%s: unexpected level %d", path, level)
+		}
+	}
+	var commandTypes []string
+	for _, msg := range broker.Requests() {
+		if strings.HasSuffix(msg.Header.Url, "/commandprocessor") {
+			var body struct{ Command struct{ CommandType string } }
+			json.Unmarshal(msg.Body, &body)
+			commandTypes = append(commandTypes, msg.Header.Url+" "+body.Command.CommandType)
+		}
+	}
+	slices.Sort(commandTypes)
+	expected := []string{
+		"/zone/1/commandprocessor GoToDimmedLevel",
+		"/zone/2/commandprocessor GoToSwitchedLevel",
+		"/zone/3/commandprocessor GoToShadeLevel",
+	}
+	if !slices.Equal(commandTypes, expected) {
+		t.Errorf("expected commands %v but got %v", expected, commandTypes)
+	}
+
+	var errResult map[string]string
+	if code := getJSON(t, handler, "/command/set_level?zone=99&level=20", &errResult); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for missing zone but got %d", code)
+	}
+
+	// Explicit types must match the zone's control type, if it is known.
+	for _, path := range []string{
+		"/command/set_level?zone=1&type=GoToSwitchedLevel&level=0",
+		"/command/set_level?zone=2&type=GoToDimmedLevel&level=50",
+		"/command/set_level?zone=1&type=Raise",
+		"/command/set_level?zone=4&level=50",
+	} {
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", path, code)
+		}
+	}
+	path := "/command/set_level?zone=4&type=GoToDimmedLevel&level=50"
+	if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+		t.Errorf("%s: unexpected response: status=%d result=%v", path, code, result)
+	}
+}
+
+func TestServeFanSpeed(t *testing.T) {
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Ceiling Fan"},
+		DeviceType:         "FanSpeedController",
+		Zones: []*leaptest.Zone{
+			{Href: "/zone/4", Name: "Fan", ControlType: "FanSpeed", Level: 100},
+		},
+	})
+	_, broker, handler := newTestServer(t, house)
+
+	var result bool
+	for _, c := range []struct {
+		path  string
+		level int
+	}{
+		{"/command/set_level?zone=4&speed=Medium", 50},
+		{"/command/set_level?zone=4&type=GoToFanSpeed&speed=Low", 25},
+		{"/command/set_level?zone=4&type=GoToFanSpeed&level=80", 100},
+		{"/command/set_level?zone=4&level=0", 0},
+	} {
+		if code := getJSON(t, handler, c.path, &result); code != http.StatusOK || !result {
+			t.Fatalf("%s: unexpected response: status=%d result=%v", c.path, code, result)
+		}
+		if level := broker.ZoneLevel("/zone/4"); level != c.level {
+			t.Errorf("%s: unexpected level %d", c.path, level)
+		}
+	}
+
+	var devices []*leap.DeviceInfo
+	leaptest.WaitFor(t, func() bool {
+		getJSON(t, handler, "/devices", &devices)
+		fan := findDevice(devices, "Ceiling Fan")
+		return fan.FanSpeed != nil && *fan.FanSpeed == "Off"
+	})
+
+	var errResult map[string]string
+	for _, path := range []string{
+		"/command/set_level?zone=4&speed=Fast",
+		"/command/set_level?zone=4&type=GoToDimmedLevel&speed=Low",
+		"/command/set_level?zone=4&speed=Low&fade=2",
+		"/command/set_level?zone=1&speed=Low",
+	} {
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", path, code)
+		}
+	}
+
+	// Fans are only turned off by all_off when asked.
+	broker.SetZoneLevel("/zone/4", 75)
+	var offResult map[string]bool
+	if code := getJSON(t, handler, "/command/all_off", &offResult); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if level := broker.ZoneLevel("/zone/4"); level != 75 {
+		t.Errorf("fan should be left on, but its level is %d", level)
+	}
+	if code := getJSON(t, handler, "/command/all_off?fans=true&delay=1", &offResult); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if level := broker.ZoneLevel("/zone/4"); level != 0 {
+		t.Errorf("fan should be off, but its level is %d", level)
+	}
+}
+
+func TestServeShades(t *testing.T) {
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Blinds"},
+		DeviceType:         "SerenaTiltOnlyWoodBlind",
+		Area:               "/area/4",
+		Zones: []*leaptest.Zone{
+			{Href: "/zone/4", Name: "Blinds", ControlType: "ShadeWithTilt", Level: 100, Tilt: 50},
+		},
+	})
+	server, broker, handler := newTestServer(t, house)
+
+	configPath := filepath.Join(t.TempDir(), "shades.json")
+	config := `{"Positions": {"/zone/1": {"privacy": 50}, "/zone/3": {"privacy": 30}, "/zone/4": {"privacy": 20}},
+		"Groups": {"all": ["/zone/3", "/zone/4"]}}`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	shadeConfig, err := LoadShadeConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetShadeConfig(shadeConfig)
+
+	var result bool
+	for _, c := range []struct {
+		path  string
+		zone  string
+		level int
+		tilt  int
+	}{
+		{"/command/set_level?zone=4&level=60", "/zone/4", 60, 50},
+		{"/command/set_level?zone=3&position=privacy", "/zone/3", 30, 0},
+		{"/command/set_tilt?zone=4&tilt=80", "/zone/4", 60, 80},
+	} {
+		if code := getJSON(t, handler, c.path, &result); code != http.StatusOK || !result {
+			t.Fatalf("%s: unexpected response: status=%d result=%v", c.path, code, result)
+		}
+		if level, tilt := broker.ZoneLevel(c.zone), broker.ZoneTilt(c.zone); level != c.level || tilt != c.tilt {
+			t.Errorf("%s: unexpected level %d and tilt %d", c.path, level, tilt)
+		}
+	}
+
+	var devices []*leap.DeviceInfo
+	leaptest.WaitFor(t, func() bool {
+		getJSON(t, handler, "/devices", &devices)
+		blinds := findDevice(devices, "Blinds")
+		return blinds.Zones[0].Tilt != nil && *blinds.Zones[0].Tilt == 80
+	})
+
+	var groupResult map[string]bool
+	path := "/command/shade_group?group=all&position=privacy"
+	if code := getJSON(t, handler, path, &groupResult); code != http.StatusOK || !groupResult["data"] {
+		t.Fatalf("%s: unexpected response: status=%d result=%v", path, code, groupResult)
+	}
+	if l1, l2 := broker.ZoneLevel("/zone/3"), broker.ZoneLevel("/zone/4"); l1 != 30 || l2 != 20 {
+		t.Errorf("unexpected group levels %d and %d", l1, l2)
+	}
+	path = "/command/shade_group?group=all&level=100"
+	if code := getJSON(t, handler, path, &groupResult); code != http.StatusOK {
+		t.Fatalf("%s: unexpected status %d", path, code)
+	}
+	if l1, l2 := broker.ZoneLevel("/zone/3"), broker.ZoneLevel("/zone/4"); l1 != 100 || l2 != 100 {
+		t.Errorf("unexpected group levels %d and %d", l1, l2)
+	}
+
+	var errResult map[string]string
+	for _, c := range []struct {
+		path string
+		code int
+	}{
+		{"/command/set_tilt?zone=3&tilt=50", http.StatusBadRequest},
+		{"/command/set_tilt?zone=4&tilt=101", http.StatusBadRequest},
+		{"/command/set_level?zone=3&position=open", http.StatusBadRequest},
+		{"/command/set_level?zone=1&position=privacy", http.StatusBadRequest},
+		{"/command/shade_group?group=missing&level=0", http.StatusNotFound},
+		{"/command/shade_group?group=all&level=0&tilt=0", http.StatusBadRequest},
+		{"/command/shade_group?group=all&tilt=0", http.StatusBadRequest},
+	} {
+		if code := getJSON(t, handler, c.path, &errResult); code != c.code {
+			t.Errorf("%s: expected status %d but got %d", c.path, c.code, code)
+		}
+	}
+
+	var served ShadeConfig
+	if code := getJSON(t, handler, "/shades", &served); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if !reflect.DeepEqual(&served, shadeConfig) {
+		t.Errorf("unexpected shade config: %+v", served)
+	}
+
+	if err := os.WriteFile(configPath, []byte(`{"Groups": {"bad": ["3"]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadShadeConfig(configPath); err == nil {
+		t.Error("expected error for invalid zone href")
+	}
+}
+
+func TestServeSetColor(t *testing.T) {
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Downlight"},
+		DeviceType:         "KetraD3",
+		Zones: []*leaptest.Zone{
+			{Href: "/zone/4", Name: "Downlight", ControlType: "SpectrumTune", Level: 50},
+		},
+	}, &leaptest.Device{
+		Href:               "/device/7",
+		FullyQualifiedName: []string{"Bedroom", "Reading Lamp"},
+		DeviceType:         "WhiteTuneDimmer",
+		Zones: []*leaptest.Zone{
+			{
+				Href:        "/zone/5",
+				Name:        "Reading Lamp",
+				ControlType: "WhiteTune",
+				Level:       50,
+				KelvinRange: &leap.KelvinRange{Min: 1800, Max: 3000},
+			},
+		},
+	})
+	_, broker, handler := newTestServer(t, house)
+
+	var result bool
+	for _, path := range []string{
+		"/command/set_color?zone=4&hue=120&saturation=80&vibrancy=30&level=70&fade=2",
+		"/command/set_color?zone=5&kelvin=2200",
+	} {
+		if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+			t.Fatalf("%s: unexpected response: status=%d result=%v", path, code, result)
+		}
+	}
+	if level := broker.ZoneLevel("/zone/4"); level != 70 {
+		t.Errorf("unexpected level %d", level)
+	}
+
+	var devices []*leap.DeviceInfo
+	leaptest.WaitFor(t, func() bool {
+		getJSON(t, handler, "/devices", &devices)
+		downlight := findDevice(devices, "Downlight").Zones[0]
+		lamp := findDevice(devices, "Reading Lamp").Zones[0]
+		return downlight.Color != nil && downlight.Color.HSVTuningLevel != nil &&
+			*downlight.Color.HSVTuningLevel == leap.HSVTuningLevel{Hue: 120, Saturation: 80} &&
+			downlight.Vibrancy != nil && *downlight.Vibrancy == 30 &&
+			lamp.Color != nil && lamp.Color.WhiteTuningLevel != nil &&
+			lamp.Color.WhiteTuningLevel.Kelvin == 2200 && *lamp.Level == 50
+	})
+	lamp := findDevice(devices, "Reading Lamp").Zones[0]
+	if lamp.KelvinRange == nil || *lamp.KelvinRange != (leap.KelvinRange{Min: 1800, Max: 3000}) {
+		t.Errorf("unexpected kelvin range: %v", lamp.KelvinRange)
+	}
+
+	var errResult map[string]string
+	for _, path := range []string{
+		"/command/set_color?zone=5&kelvin=4000",
+		"/command/set_color?zone=5&hue=120&saturation=80",
+		"/command/set_color?zone=4&hue=120",
+		"/command/set_color?zone=4&kelvin=2700&hue=120&saturation=80",
+		"/command/set_color?zone=4&hue=400&saturation=80",
+		"/command/set_color?zone=1&kelvin=2700",
+	} {
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", path, code)
+		}
+	}
+}
+
+func TestServeSetHVAC(t *testing.T) {
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Thermostat"},
+		DeviceType:         "Thermostat",
+		Zones: []*leaptest.Zone{
+			{
+				Href:        "/zone/4",
+				Name:        "Thermostat",
+				ControlType: "HVAC",
+				HVAC: &leaptest.HVAC{
+					Temperature:   21.5,
+					HeatSetPoint:  20,
+					CoolSetPoint:  25,
+					OperatingMode: "Heat",
+					FanMode:       "Auto",
+				},
+			},
+		},
+	})
+	_, broker, handler := newTestServer(t, house)
+
+	var result bool
+	for _, path := range []string{
+		"/command/set_hvac?zone=4&heat=70&unit=F",
+		"/command/set_hvac?zone=4&mode=Cool",
+	} {
+		if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+			t.Fatalf("%s: unexpected response: status=%d result=%v", path, code, result)
+		}
+	}
+	expected := leaptest.HVAC{
+		Temperature:   21.5,
+		HeatSetPoint:  21.1,
+		CoolSetPoint:  25,
+		OperatingMode: "Cool",
+		FanMode:       "Auto",
+	}
+	if hvac := broker.ZoneHVAC("/zone/4"); hvac != expected {
+		t.Errorf("unexpected HVAC state: %+v", hvac)
+	}
+
+	var devices []*leap.DeviceInfo
+	leaptest.WaitFor(t, func() bool {
+		getJSON(t, handler, "/devices", &devices)
+		hvac := findDevice(devices, "Thermostat").Zones[0].HVAC
+		return hvac != nil && hvac.OperatingMode == "Cool"
+	})
+	hvac := findDevice(devices, "Thermostat").Zones[0].HVAC
+	if *hvac.HeatSetPoint != (leap.Temperature{Celsius: 21.1, Fahrenheit: 70}) ||
+		*hvac.CurrentTemperature != (leap.Temperature{Celsius: 21.5, Fahrenheit: 70.7}) {
+		t.Errorf("unexpected HVAC info: %+v", hvac)
+	}
+
+	var errResult map[string]string
+	for _, path := range []string{
+		"/command/set_hvac?zone=4",
+		"/command/set_hvac?zone=4&heat=26&cool=25",
+		"/command/set_hvac?zone=4&heat=25",
+		"/command/set_hvac?zone=4&cool=21",
+		"/command/set_hvac?zone=4&cool=100",
+		"/command/set_hvac?zone=4&heat=NaN",
+		"/command/set_hvac?zone=4&heat=NaN&wait=false",
+		"/command/set_hvac?zone=4&cool=Inf&unit=F",
+		"/command/set_hvac?zone=4&cool=75&unit=K",
+		"/command/set_hvac?zone=4&mode=Warm",
+		"/command/set_hvac?zone=1&mode=Heat",
+		"/command/set_level?zone=4&level=50",
+	} {
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", path, code)
+		}
+	}
+
+	// Quick changes to different settings must not undo each other while
+	// they wait in the queue.
+	broker.SetLatency(time.Millisecond * 20)
+	for _, path := range []string{
+		"/command/set_hvac?zone=4&heat=21.5&wait=false",
+		"/command/set_hvac?zone=4&cool=26&wait=false",
+		"/command/set_hvac?zone=4&mode=Auto&wait=false",
+		"/command/set_hvac?zone=4&fan=On&wait=false",
+	} {
+		if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+			t.Fatalf("%s: unexpected response: status=%d result=%v", path, code, result)
+		}
+	}
+	expected = leaptest.HVAC{
+		Temperature:   21.5,
+		HeatSetPoint:  21.5,
+		CoolSetPoint:  26,
+		OperatingMode: "Auto",
+		FanMode:       "On",
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.RequestCount("/zone/4/commandprocessor") == 6 && broker.ZoneHVAC("/zone/4") == expected
+	})
+
+	// Errors say which of the commands failed.
+	broker.FailURL("/zone/4/commandprocessor", "500 InternalServerError")
+	path := "/command/set_hvac?zone=4&heat=21&mode=Heat"
+	if code := getJSON(t, handler, path, &errResult); code != http.StatusInternalServerError {
+		t.Errorf("%s: expected status 500 but got %d", path, code)
+	} else if msg := errResult["error"]; !strings.Contains(msg, "change setpoints") ||
+		!strings.Contains(msg, "change mode") {
+		t.Errorf("%s: error does not name the failed commands: %s", path, msg)
+	}
+}
+
+func TestServeSetLevelFadeAndDelay(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var result bool
+	path := "/command/set_level?zone=1&level=30&fade=1m30s&delay=2"
+	if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+		t.Fatalf("unexpected response: status=%d result=%v", code, result)
+	}
+	var params map[string]any
+	for _, msg := range broker.Requests() {
+		if msg.Header.Url == "/zone/1/commandprocessor" {
+			var body struct {
+				Command struct{ DimmedLevelParameters map[string]any }
+			}
+			json.Unmarshal(msg.Body, &body)
+			params = body.Command.DimmedLevelParameters
+		}
+	}
+	if params["FadeTime"] != "00:01:30" || params["DelayTime"] != "00:00:02" {
+		t.Errorf("unexpected command parameters: %v", params)
+	}
+
+	var errResult map[string]string
+	for _, path := range []string{
+		"/command/set_level?zone=1&level=30&fade=abc",
+		"/command/set_level?zone=1&level=30&fade=0.5",
+		"/command/set_level?zone=1&level=30&delay=5h",
+		"/command/set_level?zone=2&level=100&fade=5",
+		"/command/set_level?zone=3&type=Raise&delay=5",
+	} {
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", path, code)
+		}
+	}
+
+	// Errors for durations which cannot be converted report the input.
+	for _, fade := range []string{"NaN", "Inf", "-Inf", "1e300", "-1e300"} {
+		path := "/command/set_level?zone=1&level=30&fade=" + fade
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 but got %d", path, code)
+		} else if msg := errResult["error"]; msg != "invalid fade: "+fade+" is out of range" {
+			t.Errorf("%s: unexpected error: %s", path, msg)
+		}
+	}
+}
+
+func TestServePressAndRelease(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var result bool
+	if code := getJSON(t, handler, "/command/press_and_release?button=10", &result); code != http.StatusOK || !result {
+		t.Fatalf("unexpected response: status=%d result=%v", code, result)
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.ZoneLevel("/zone/1") == 75
+	})
+	if pressed := broker.Pressed(); len(pressed) != 1 || pressed[0] != "/button/10" {
+		t.Errorf("unexpected pressed buttons: %v", pressed)
+	}
+}
+
+func TestServeSetLevelCollapse(t *testing.T) {
+	server, broker, handler := newTestServer(t, leaptest.SampleHouse())
+	broker.SetLatency(time.Millisecond * 20)
+
+	// Simulate dragging a slider: only the first and last levels need to be
+	// sent, since the others are superseded while the first is in flight.
+	var result bool
+	for level := 1; level <= 10; level++ {
+		path := fmt.Sprintf("/command/set_level?type=GoToDimmedLevel&zone=1&level=%d&wait=false", level*10)
+		if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+			t.Fatalf("unexpected response: status=%d result=%v", code, result)
+		}
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.ZoneLevel("/zone/1") == 100
+	})
+	if n := broker.RequestCount("/zone/1/commandprocessor"); n >= 10 {
+		t.Errorf("expected superseded commands to be dropped, but sent %d", n)
+	}
+	if server.commands.Collapsed() == 0 {
+		t.Error("expected collapsed commands")
+	}
+
+	// Relative movements must all be sent, in order.
+	for _, commandType := range []string{"Raise", "Stop"} {
+		path := "/command/set_level?type=" + commandType + "&zone=3&wait=false"
+		getJSON(t, handler, path, &result)
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.RequestCount("/zone/3/commandprocessor") == 2
+	})
+}
+
+func TestServeSetColorCollapse(t *testing.T) {
+	house := leaptest.SampleHouse()
+	house.Devices = append(house.Devices, &leaptest.Device{
+		Href:               "/device/6",
+		FullyQualifiedName: []string{"Bedroom", "Downlight"},
+		DeviceType:         "KetraD3",
+		Zones: []*leaptest.Zone{
+			{Href: "/zone/4", Name: "Downlight", ControlType: "SpectrumTune", Level: 50},
+		},
+	})
+	_, broker, handler := newTestServer(t, house)
+	broker.SetLatency(time.Millisecond * 20)
+
+	// The color and the level are queued behind the first level, and the
+	// level must not replace the color even though they share a CommandType.
+	var result bool
+	for _, path := range []string{
+		"/command/set_level?zone=4&level=10&wait=false",
+		"/command/set_color?zone=4&kelvin=2700&wait=false",
+		"/command/set_level?zone=4&level=80&wait=false",
+	} {
+		if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+			t.Fatalf("%s: unexpected response: status=%d result=%v", path, code, result)
+		}
+	}
+	leaptest.WaitFor(t, func() bool {
+		return broker.RequestCount("/zone/4/commandprocessor") == 3
+	})
+	var devices []*leap.DeviceInfo
+	leaptest.WaitFor(t, func() bool {
+		getJSON(t, handler, "/devices", &devices)
+		zone := findDevice(devices, "Downlight").Zones[0]
+		return zone.Level != nil && *zone.Level == 80
+	})
+	zone := findDevice(devices, "Downlight").Zones[0]
+	if zone.Color == nil || zone.Color.WhiteTuningLevel == nil || zone.Color.WhiteTuningLevel.Kelvin != 2700 {
+		t.Errorf("color was not applied: %+v", zone.Color)
+	}
+}
+
+func TestServeCommandAcknowledgement(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	var errResult map[string]string
+	path := "/command/set_level?type=GoToDimmedLevel&zone=99&level=30"
+	if code := getJSON(t, handler, path, &errResult); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for missing zone but got %d", code)
+	}
+	broker.FailURL("/zone/1/commandprocessor", "404 NotFound")
+	path = "/command/set_level?type=GoToDimmedLevel&zone=1&level=30"
+	if code := getJSON(t, handler, path, &errResult); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for rejected command but got %d", code)
+	}
+	path = "/command/set_level?type=GoToDimmedLevel&zone=1&level=30&wait=maybe"
+	if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid wait but got %d", code)
+	}
+
+	var result bool
+	path = "/command/set_level?type=GoToDimmedLevel&zone=1&level=30&wait=false"
+	if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+		t.Errorf("unexpected fire-and-forget response: status=%d result=%v", code, result)
+	}
+
+	broker.FailURL("/virtualbutton/1/commandprocessor", "500 InternalServerError")
+	if code := getJSON(t, handler, "/scene/activate?scene=1", &errResult); code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 for rejected scene but got %d", code)
+	}
+}
+
+func TestServeLEAPErrorStatus(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+
+	for status, expected := range map[string]int{
+		"404 NotFound":            http.StatusNotFound,
+		"400 BadRequest":          http.StatusBadRequest,
+		"401 Unauthorized":        http.StatusUnauthorized,
+		"500 InternalServerError": http.StatusInternalServerError,
+	} {
+		broker.FailURL("/zone/status", status)
+		var result map[string]string
+		if code := getJSON(t, handler, "/devices", &result); code != expected {
+			t.Errorf("%s: expected HTTP status %d but got %d", status, expected, code)
+		} else if result["error"] == "" {
+			t.Errorf("%s: missing error message", status)
+		}
+	}
+}
+
+func TestServeDevicesCoalescing(t *testing.T) {
+	_, broker, handler := newTestServer(t, leaptest.SampleHouse())
+	broker.SetLatency(time.Millisecond * 20)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var devices []*leap.DeviceInfo
+			if code := getJSON(t, handler, "/devices", &devices); code != http.StatusOK {
+				t.Errorf("unexpected status %d", code)
+			} else if len(devices) != 5 {
+				t.Errorf("unexpected device count %d", len(devices))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := broker.RequestCount("/device"); n != 1 {
+		t.Errorf("expected one device read but got %d", n)
+	}
+	var stats map[string]int64
+	getJSON(t, handler, "/stats", &stats)
+	if stats["CoalescedDeviceLoads"] != 4 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+func TestServeLocalTransport(t *testing.T) {
+	broker := leaptest.NewBroker(leaptest.SampleHouse())
+	bridge, err := leaptest.NewLocalServer(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		bridge.Close()
+		broker.Close()
+	}()
+
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "state.json")
+	server, err := NewServer(dir, savePath, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge.PressButton()
+	if err := server.PairLocalBridge(context.Background(), bridge.PairingAddr, bridge.PairingCredentials); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := NewServerState(savePath); err != nil {
+		t.Fatal(err)
+	} else if state.LocalCreds() == nil {
+		t.Fatal("local credentials were not saved")
+	}
+
+	server.UseLocalBridge(bridge.Addr)
+	handler := server.addRoutes()
+	var devices []*leap.DeviceInfo
+	if code := getJSON(t, handler, "/devices", &devices); code != http.StatusOK || len(devices) != 5 {
+		t.Fatalf("unexpected response: status=%d devices=%d", code, len(devices))
+	}
+	conn := server.connection
+
+	// The server should reconnect after the bridge drops the connection.
+	bridge.CloseConnections()
+	leaptest.WaitFor(t, func() bool {
+		return conn.Error() != nil
+	})
+	var result bool
+	path := "/command/set_level?type=GoToDimmedLevel&zone=1&level=20"
+	if code := getJSON(t, handler, path, &result); code != http.StatusOK || !result {
+		t.Fatalf("unexpected response after reconnect: status=%d result=%v", code, result)
+	}
+	if broker.ZoneLevel("/zone/1") != 20 {
+		t.Errorf("command was not applied after reconnect")
+	}
+	server.connection.Close()
+}
+
+func TestServeDebugLEAP(t *testing.T) {
+	server, broker, handler := newTestServer(t, leaptest.SampleHouse())
+	server.connection = nil
+	server.SetTrace(leap.NewTrace(100))
+	server.SetConnector(func(ctx context.Context) (leap.BrokerConn, error) {
+		return broker, nil
+	})
+	var devices []*leap.DeviceInfo
+	if code := getJSON(t, handler, "/devices", &devices); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+
+	var errResult map[string]string
+	if code := getJSON(t, handler, "/debug/leap", &errResult); code != http.StatusNotFound {
+		t.Errorf("expected admin endpoints to be disabled, got status %d", code)
+	}
+	server.SetAdminToken("letmein")
+	if code := getJSON(t, handler, "/debug/leap?token=wrong", &errResult); code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for wrong token but got %d", code)
+	}
+
+	var entries []leap.TraceEntry
+	if code := getJSON(t, handler, "/debug/leap?token=letmein&url_prefix=/device", &entries); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if len(entries) == 0 || entries[0].Url != "/device" || entries[0].Response == nil {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	getJSON(t, handler, "/debug/leap?token=letmein&type=SubscribeRequest", &entries)
+	for _, e := range entries {
+		if e.CommuniqueType != "SubscribeRequest" {
+			t.Errorf("unexpected entry for type filter: %+v", e)
+		}
+	}
+
+	// Filters in a form-encoded body are ignored.
+	formReq := httptest.NewRequest(http.MethodPost, "/debug/leap?token=letmein&url_prefix=/device",
+		strings.NewReader("url_prefix=/zone"))
+	formReq.Header.Set("content-type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, formReq)
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || entries[0].Url != "/device" {
+		t.Errorf("unexpected entries for form body: %+v", entries)
+	}
+
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+	req, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/debug/leap?follow=true&url_prefix=/zone/1/", nil)
+	req.Header.Set("authorization", "Bearer letmein")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result bool
+	getJSON(t, handler, "/command/set_level?type=GoToDimmedLevel&zone=1&level=40", &result)
+	var entry leap.TraceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Url != "/zone/1/commandprocessor" || entry.CommuniqueType != "CreateRequest" {
+		t.Errorf("unexpected streamed entry: %+v", entry)
+	}
+}
+
+func TestServeAdminLEAP(t *testing.T) {
+	server, broker, handler := newTestServer(t, leaptest.SampleHouse())
+	server.SetAdminToken("letmein")
+
+	var response leap.Message
+	if code := getJSON(t, handler, "/admin/leap?token=letmein&url=/zone/1/status", &response); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	var status struct{ ZoneStatus leap.ZoneStatus }
+	if err := json.Unmarshal(response.Body, &status); err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.StatusCode != "200 OK" || status.ZoneStatus.Level != 50 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+
+	// Errors from the bridge are passed through as-is.
+	response = leap.Message{}
+	if code := getJSON(t, handler, "/admin/leap?token=letmein&url=/zone/99", &response); code != http.StatusNotFound {
+		t.Errorf("expected status 404 but got %d", code)
+	}
+	if response.Header.StatusCode != "404 NotFound" || len(response.Body) == 0 {
+		t.Errorf("unexpected error response: %+v", response)
+	}
+
+	var errResult map[string]string
+	for _, url := range []string{"", "zone/1", "/zone/../device", "/zone/1/", "/zone/1?x=1", "/zone 1"} {
+		path := "/admin/leap?token=letmein&url=" + neturl.QueryEscape(url)
+		if code := getJSON(t, handler, path, &errResult); code != http.StatusBadRequest {
+			t.Errorf("url %q: expected status 400 but got %d", url, code)
+		}
+	}
+
+	post := func(path, body string, out any) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("authorization", "Bearer letmein")
+		// Like curl -d, which must not cause the body to be parsed as a form.
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: decode response %q: %s", path, rec.Body.String(), err)
+		}
+		return rec.Code
+	}
+	command := `{"Command":{"CommandType":"GoToDimmedLevel","DimmedLevelParameters":{"Level":20}}}`
+	createPath := "/admin/leap?type=CreateRequest&url=/zone/1/commandprocessor"
+	if code := post(createPath, command, &errResult); code != http.StatusForbidden {
+		t.Errorf("expected writes to be forbidden, got status %d", code)
+	}
+	server.SetAdminWrites(true)
+	if code := post(createPath, "{", &errResult); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid body but got %d", code)
+	}
+	response = leap.Message{}
+	if code := post(createPath, command, &response); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if response.CommuniqueType != "CreateResponse" || broker.ZoneLevel("/zone/1") != 20 {
+		t.Errorf("unexpected create response: %+v", response)
+	}
+	if code := getJSON(t, handler, "/admin/leap?token=letmein&type=CreateRequest&url=/zone/1/commandprocessor", &errResult); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected writes to require POST, got status %d", code)
+	}
+	if code := getJSON(t, handler, "/admin/leap?token=letmein&type=DeleteRequest&url=/zone/1", &errResult); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unsupported type but got %d", code)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/lutroncontrol/leap"
+)
+
+var zoneHrefPattern = regexp.MustCompile(`^/zone/[0-9]+$`)
+
+// ShadeConfig configures named shade positions and groups of shades which
+// move together.
+type ShadeConfig struct {
+	// Positions maps zone hrefs to named levels, e.g. "privacy" = 30.
+	Positions map[string]map[string]int `json:",omitempty"`
+
+	// Groups maps group names to the hrefs of their zones.
+	Groups map[string][]string `json:",omitempty"`
+}
+
+// LoadShadeConfig reads a ShadeConfig from a JSON file.
+func LoadShadeConfig(path string) (config *ShadeConfig, err error) {
+	defer essentials.AddCtxTo("load shade config", &err)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config = &ShadeConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for zone, positions := range config.Positions {
+		if !zoneHrefPattern.MatchString(zone) {
+			return nil, fmt.Errorf("invalid zone: %s", zone)
+		}
+		for name, level := range positions {
+			if level < 0 || level > 100 {
+				return nil, fmt.Errorf("position %s of %s is out of range", name, zone)
+			}
+		}
+	}
+	for group, zones := range config.Groups {
+		if len(zones) == 0 {
+			return nil, fmt.Errorf("group %s is empty", group)
+		}
+		for _, zone := range zones {
+			if !zoneHrefPattern.MatchString(zone) {
+				return nil, fmt.Errorf("invalid zone in group %s: %s", group, zone)
+			}
+		}
+	}
+	return config, nil
+}
+
+// SetShadeConfig sets the named positions and groups used by shade commands.
+//
+// This should be called before the server starts handling requests.
+func (s *Server) SetShadeConfig(config *ShadeConfig) {
+	s.shades = config
+}
+
+// shadePosition looks up the level of a named position for a zone.
+func (s *Server) shadePosition(zoneHref, name string) (int, error) {
+	if s.shades != nil {
+		if level, ok := s.shades.Positions[zoneHref][name]; ok {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown position for %s: %s", zoneHref, name)
+}
+
+func (s *Server) serveShades(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		if s.shades == nil {
+			return &ShadeConfig{}, http.StatusOK, nil
+		}
+		return s.shades, http.StatusOK, nil
+	})
+}
+
+func (s *Server) serveSetTilt(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		zone := r.FormValue("zone")
+		if _, err := strconv.Atoi(zone); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid zone: %w", err)
+		}
+		tilt, err := strconv.Atoi(r.FormValue("tilt"))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid tilt: %w", err)
+		}
+		action := &shadeAction{Tilt: &tilt}
+		cmd, status, err := s.shadeCommand(r.Context(), client, "/zone/"+zone, action)
+		if err != nil {
+			return nil, status, err
+		}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, cmd); err != nil {
+			return false, http.StatusInternalServerError, err
+		}
+		return true, http.StatusOK, nil
+	})
+}
+
+func (s *Server) serveShadeGroup(w http.ResponseWriter, r *http.Request) {
+	s.handleGetCall(w, func(client *leap.Client) (any, int, error) {
+		wait, err := waitParam(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		name := r.FormValue("group")
+		var zones []string
+		if s.shades != nil {
+			zones = s.shades.Groups[name]
+		}
+		if len(zones) == 0 {
+			return nil, http.StatusNotFound, fmt.Errorf("unknown shade group: %s", name)
+		}
+		action, err := shadeActionParams(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		var commands []queuedCommand
+		for _, zone := range zones {
+			cmd, status, err := s.shadeCommand(r.Context(), client, zone, action)
+			if err != nil {
+				return nil, status, err
+			}
+			commands = append(commands, cmd)
+		}
+		if err := s.queueCommands(r.Context(), client.Conn, wait, commands...); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return map[string]bool{"data": true}, http.StatusOK, nil
+	})
+}
+
+// A shadeAction is one of a level, a named position, a tilt, or a Raise,
+// Lower or Stop command.
+type shadeAction struct {
+	Level       *int
+	Position    string
+	Tilt        *int
+	CommandType string
+}
+
+// shadeActionParams parses the action of a shade group command, which must
+// be exactly one of the "level", "position", "tilt" or "type" arguments.
+func shadeActionParams(r *http.Request) (*shadeAction, error) {
+	action := &shadeAction{}
+	var count int
+	var err error
+	if action.Level, err = intParam(r, "level"); err != nil {
+		return nil, err
+	} else if action.Level != nil {
+		count++
+	}
+	if action.Tilt, err = intParam(r, "tilt"); err != nil {
+		return nil, err
+	} else if action.Tilt != nil {
+		count++
+	}
+	if action.Position = r.FormValue("position"); action.Position != "" {
+		count++
+	}
+	if action.CommandType = r.FormValue("type"); action.CommandType != "" {
+		if !isShadeMovement(action.CommandType) {
+			return nil, fmt.Errorf("unknown command type: %s", action.CommandType)
+		}
+		count++
+	}
+	if count != 1 {
+		return nil, errors.New("expected exactly one of level, position, tilt, or type")
+	}
+	return action, nil
+}
+
+// shadeCommand builds the command which applies an action to a shade,
+// returning an HTTP status along with any error.
+func (s *Server) shadeCommand(
+	ctx context.Context,
+	client *leap.Client,
+	zoneHref string,
+	action *shadeAction,
+) (queuedCommand, int, error) {
+	cmd := queuedCommand{Url: zoneHref + "/commandprocessor", Idempotent: true}
+	if action.CommandType != "" {
+		cmd.Body = map[string]any{"Command": map[string]any{"CommandType": action.CommandType}}
+		cmd.Idempotent = false
+		return cmd, http.StatusOK, nil
+	}
+
+	controlType, err := s.zoneControlType(ctx, client, zoneHref)
+	if err != nil {
+		return cmd, http.StatusInternalServerError, err
+	}
+	if !isShadeControlType(controlType) {
+		return cmd, http.StatusBadRequest, fmt.Errorf("%s is not a shade", zoneHref)
+	}
+	var body map[string]any
+	if action.Tilt != nil {
+		cmd.Collapse = "tilt"
+		body, err = leap.TiltCommand(controlType, *action.Tilt)
+	} else {
+		cmd.Collapse = "level"
+		level := 0
+		if action.Level != nil {
+			level = *action.Level
+		} else if level, err = s.shadePosition(zoneHref, action.Position); err != nil {
+			return cmd, http.StatusBadRequest, err
+		}
+		body, err = leap.ZoneCommand(controlType, leap.ZoneTarget{Level: level})
+	}
+	if err != nil {
+		return cmd, http.StatusBadRequest, err
+	}
+	cmd.Body = body
+	return cmd, http.StatusOK, nil
+}
+
+// isShadeControlType checks if a zone control type is a shade, including
+// tilt-only blinds.
+func isShadeControlType(controlType string) bool {
+	return controlType == "Shade" || leap.SupportsTilt(controlType)
+}
+
+// isShadeMovement checks for the commands which start or stop moving a
+// shade, rather than moving it to a level.
+func isShadeMovement(commandType string) bool {
+	return commandType == "Raise" || commandType == "Lower" || commandType == "Stop"
+}